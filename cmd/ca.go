@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/muzzii255/testgen/proxy"
+
+	"github.com/spf13/cobra"
+)
+
+var caCmd = &cobra.Command{
+	Use:   "ca",
+	Short: "Manage the local CA used to record HTTPS traffic",
+}
+
+var caInitCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Generate a local certificate authority",
+	Long: `Generates the certificate authority the forward proxy uses to intercept
+HTTPS traffic. Add the printed certificate to the trust store of the client
+being recorded.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		dir, _ := cmd.Flags().GetString("dir")
+		certPath, err := proxy.InitCA(dir)
+		if err != nil {
+			slog.Error("failed to create CA", "err", err)
+			return
+		}
+		fmt.Printf("CA certificate written to %s\n", certPath)
+		fmt.Println("Trust it in the client you record, for example with SSL_CERT_FILE for Go programs.")
+	},
+}
+
+func defaultCADir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(".testgen", "ca")
+	}
+	return filepath.Join(home, ".testgen", "ca")
+}
+
+func init() {
+	rootCmd.AddCommand(caCmd)
+	caCmd.AddCommand(caInitCmd)
+	caInitCmd.Flags().String("dir", defaultCADir(), "Directory to write the CA certificate and key to")
+}
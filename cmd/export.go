@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"log/slog"
+	"path/filepath"
+
+	"github.com/muzzii255/testgen/har"
+	"github.com/muzzii255/testgen/proxy"
+
+	"github.com/spf13/cobra"
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export recordings to other formats",
+}
+
+var exportHarCmd = &cobra.Command{
+	Use:   "har",
+	Short: "Convert recordings into a HAR file",
+	Long:  `Converts recordings into an HTTP Archive (HAR) file that can be opened in standard HAR viewers.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		patterns, _ := cmd.Flags().GetStringSlice("file")
+		patterns = append(patterns, args...)
+		output, _ := cmd.Flags().GetString("output")
+		baseURL, _ := cmd.Flags().GetString("base-url")
+
+		recordings := make(map[string]proxy.Recording)
+		for _, pattern := range patterns {
+			files, err := filepath.Glob(pattern)
+			if err != nil {
+				slog.Error("invalid file pattern", "pattern", pattern, "err", err)
+				return
+			}
+			for _, f := range files {
+				rcrds, err := proxy.ReadRecordings(f)
+				if err != nil {
+					slog.Error("error reading file", "err", err)
+					return
+				}
+				proxy.Merge(recordings, rcrds)
+			}
+		}
+
+		f, err := har.FromRecordings(recordings, baseURL)
+		if err != nil {
+			slog.Error("error converting recordings", "err", err)
+			return
+		}
+		if err := har.WriteFile(output, f); err != nil {
+			slog.Error("error writing har", "err", err)
+			return
+		}
+		slog.Info("har exported", "entries", len(f.Log.Entries), "output", output)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+	exportCmd.AddCommand(exportHarCmd)
+	exportHarCmd.Flags().StringSliceP("file", "f", nil, "Recorded JSON files to export, glob patterns are allowed")
+	exportHarCmd.Flags().StringP("output", "o", "recordings.har", "Path of the HAR file to write")
+	exportHarCmd.Flags().String("base-url", "http://localhost:8080", "Base URL used to build absolute request URLs")
+	exportHarCmd.MarkFlagRequired("file")
+}
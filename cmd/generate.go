@@ -1,8 +1,11 @@
 package cmd
 
 import (
+	"errors"
+	"fmt"
 	"log/slog"
 	"os"
+	"strings"
 
 	"github.com/muzzii255/testgen/generator"
 
@@ -25,17 +28,58 @@ Supports integration tests.`,
 			slog.Error("error parsing file flag", "err", err)
 			return
 		}
+		scenario, _ := cmd.Flags().GetBool("scenario")
+		gap, _ := cmd.Flags().GetDuration("scenario-gap")
+		discover, _ := cmd.Flags().GetBool("discover")
+		framework, _ := cmd.Flags().GetString("framework")
+		external, _ := cmd.Flags().GetBool("external")
+		golden, _ := cmd.Flags().GetBool("golden")
+		updateGolden, _ := cmd.Flags().GetBool("update-golden")
+		check, _ := cmd.Flags().GetBool("check")
+		cfg, err := loadConfig(cmd)
+		if err != nil {
+			slog.Error("failed to load config", "err", err)
+			return
+		}
+		ignoreFields, _ := cmd.Flags().GetStringSlice("ignore-field")
+		typeOnlyFields, _ := cmd.Flags().GetStringSlice("type-only-field")
+		cfg.Assert.Ignore = append(cfg.Assert.Ignore, ignoreFields...)
+		cfg.Assert.TypeOnly = append(cfg.Assert.TypeOnly, typeOnlyFields...)
 		jsonFile := generator.JsonFile{
-			Filename: fileLoc,
-			BaseDir:  cwd,
+			Filename:     fileLoc,
+			BaseDir:      cwd,
+			Scenario:     scenario,
+			ScenarioGap:  gap,
+			Discover:     discover,
+			Framework:    framework,
+			External:     external,
+			Assert:       cfg.Assert,
+			Golden:       golden,
+			UpdateGolden: updateGolden,
+			Check:        check,
 		}
 		err = jsonFile.ReadFile()
+		var diags generator.Diagnostics
+		if errors.As(err, &diags) {
+			for _, d := range diags {
+				fmt.Fprintln(os.Stderr, d)
+			}
+			fmt.Fprintf(os.Stderr, "%d invalid @testgen annotations\n", len(diags))
+			os.Exit(1)
+		}
 		if err != nil {
 			slog.Error("error reading file", "err", err)
 			return
 
 		}
 		err = jsonFile.GenTest()
+		if errors.As(err, &diags) {
+			for _, d := range diags {
+				fmt.Fprintln(os.Stderr, d)
+			}
+			fmt.Fprintf(os.Stderr, "%d compile errors in the generated tests, they were not written\n", len(diags))
+			os.Exit(1)
+		}
 		if err != nil {
 			slog.Error("error generating test files", "err", err)
 			return
@@ -47,5 +91,15 @@ Supports integration tests.`,
 func init() {
 	rootCmd.AddCommand(generateCmd)
 	generateCmd.Flags().StringP("file", "f", "", "Path to the recorded JSON file used to generate test cases.")
+	generateCmd.Flags().Bool("scenario", false, "Read --file as a recorded timeline and generate one test per user flow")
+	generateCmd.Flags().Duration("scenario-gap", generator.DefaultScenarioGap, "Start a new flow when a client is idle for longer than this (0 never splits)")
+	generateCmd.Flags().Bool("discover", true, "Find routes and request structs in the router setup code, annotations take precedence")
+	generateCmd.Flags().String("framework", "", "Framework of the generated test harness ("+strings.Join(generator.Frameworks(), ", ")+"), detected from go.mod when empty")
+	generateCmd.Flags().Bool("external", false, "Generate a harness sending requests to a running server at TESTGEN_BASE_URL")
+	generateCmd.Flags().StringSlice("ignore-field", nil, "Response field not compared with the recording, by key name or JSONPath like $.meta.etag")
+	generateCmd.Flags().StringSlice("type-only-field", nil, "Response field only checked for its JSON type, by key name or JSONPath")
+	generateCmd.Flags().Bool("golden", false, "Write expected response bodies to golden files in gentest/testdata instead of the tests")
+	generateCmd.Flags().Bool("update-golden", false, "Overwrite existing golden files with the recorded responses, only missing ones are written otherwise")
+	generateCmd.Flags().Bool("check", true, "Type check the generated tests with the module and fail instead of writing tests that don't compile")
 	generateCmd.MarkFlagRequired("file")
 }
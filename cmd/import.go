@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"log/slog"
+	"os"
+
+	"github.com/muzzii255/testgen/har"
+	"github.com/muzzii255/testgen/proxy"
+
+	"github.com/spf13/cobra"
+)
+
+var importCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Import recordings from other formats",
+}
+
+var importHarCmd = &cobra.Command{
+	Use:   "har",
+	Short: "Convert a HAR file into recordings",
+	Long: `Converts an HTTP Archive (HAR) file, for example one exported from browser
+devtools, into recordings that can be used by gen, replay and mock. Secrets
+are redacted with the same rules as record.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		fileLoc, _ := cmd.Flags().GetString("file")
+		output, _ := cmd.Flags().GetString("output")
+		all, _ := cmd.Flags().GetBool("all")
+
+		cfg, err := loadConfig(cmd)
+		if err != nil {
+			slog.Error("failed to load config", "err", err)
+			return
+		}
+		templater, err := proxy.NewTemplater(cfg.Paths)
+		if err != nil {
+			slog.Error("invalid path rules", "err", err)
+			return
+		}
+		redactor, err := loadRedactor(cmd, cfg)
+		if err != nil {
+			slog.Error("invalid redaction rules", "err", err)
+			return
+		}
+		f, err := har.ReadFile(fileLoc)
+		if err != nil {
+			slog.Error("error reading file", "err", err)
+			return
+		}
+		recordings, err := har.ToRecordings(f, all, templater, redactor)
+		if err != nil {
+			slog.Error("error converting har", "err", err)
+			return
+		}
+		if err := os.MkdirAll(output, 0o755); err != nil {
+			slog.Error("error creating output directory", "dir", output, "err", err)
+			return
+		}
+		if err := proxy.WriteRecordings(output, recordings); err != nil {
+			slog.Error("error writing recordings", "err", err)
+			return
+		}
+		slog.Info("har imported", "entries", len(f.Log.Entries), "endpoints", len(recordings), "output", output)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(importCmd)
+	importCmd.AddCommand(importHarCmd)
+	importHarCmd.Flags().StringP("file", "f", "", "Path to the HAR file to import.")
+	importHarCmd.Flags().StringP("output", "o", "./recordings", "Directory to write the recordings to")
+	importHarCmd.Flags().Bool("all", false, "Import every entry, not only JSON API traffic")
+	addRedactFlags(importHarCmd)
+	importHarCmd.MarkFlagRequired("file")
+}
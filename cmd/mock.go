@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/muzzii255/testgen/proxy"
+
+	"github.com/spf13/cobra"
+)
+
+var mockCmd = &cobra.Command{
+	Use:   "mock",
+	Short: "Serve recorded responses as a mock API",
+	Long: `Starts a server that answers requests from recorded traffic instead of
+forwarding them to a backend. Requests are matched on method, normalized path
+and optionally the request body.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		patterns, _ := cmd.Flags().GetStringSlice("file")
+		// unquoted globs are expanded by the shell into extra arguments
+		patterns = append(patterns, args...)
+		port, _ := cmd.Flags().GetInt("port")
+		matchBody, _ := cmd.Flags().GetBool("match-body")
+
+		templater, err := loadTemplater(cmd)
+		if err != nil {
+			slog.Error("failed to load path rules", "err", err)
+			return
+		}
+
+		recordings := make(map[string]proxy.Recording)
+		for _, pattern := range patterns {
+			files, err := filepath.Glob(pattern)
+			if err != nil {
+				slog.Error("invalid file pattern", "pattern", pattern, "err", err)
+				return
+			}
+			if len(files) == 0 {
+				slog.Error("no recordings match pattern", "pattern", pattern)
+				return
+			}
+			for _, f := range files {
+				rcrds, err := proxy.ReadRecordings(f)
+				if err != nil {
+					slog.Error("error reading file", "err", err)
+					return
+				}
+				proxy.Merge(recordings, rcrds)
+			}
+		}
+
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+
+		srv := &http.Server{Addr: fmt.Sprintf(":%d", port), Handler: proxy.NewMock(recordings, matchBody, templater)}
+		go func() {
+			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				slog.Error("server error", "err", err)
+			}
+		}()
+		slog.Info("mock server listening", "port", port, "endpoints", len(recordings))
+
+		<-stop
+		slog.Info("shutting down mock server")
+		srv.Shutdown(context.Background())
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(mockCmd)
+	mockCmd.Flags().StringSliceP("file", "f", nil, "Recorded JSON files to serve, glob patterns are allowed")
+	mockCmd.Flags().IntP("port", "p", 9000, "Port to run the mock server on")
+	mockCmd.Flags().Bool("match-body", false, "Only answer with recordings whose request body matches")
+	mockCmd.MarkFlagRequired("file")
+}
@@ -21,13 +21,46 @@ var recordCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		port, _ := cmd.Flags().GetInt("port")
 		target, _ := cmd.Flags().GetInt("target")
+		forward, _ := cmd.Flags().GetBool("forward")
+		caDir, _ := cmd.Flags().GetString("ca-dir")
 		targetURL := fmt.Sprintf("http://localhost:%d", target)
-		recorder, err := proxy.NewRecorder(targetURL, "./recordings")
+		if forward && !cmd.Flags().Changed("target") {
+			targetURL = ""
+		}
+
+		cfg, err := loadConfig(cmd)
+		if err != nil {
+			slog.Error("failed to load config", "err", err)
+			return
+		}
+		redactor, err := loadRedactor(cmd, cfg)
+		if err != nil {
+			slog.Error("invalid redaction rules", "err", err)
+			return
+		}
+
+		templater, err := proxy.NewTemplater(cfg.Paths)
+		if err != nil {
+			slog.Error("invalid path rules", "err", err)
+			return
+		}
+
+		opts := []proxy.Option{proxy.WithRedactor(redactor), proxy.WithTemplater(templater)}
+		if forward {
+			ca, err := proxy.LoadCA(caDir)
+			if err != nil {
+				slog.Warn("no CA loaded, HTTPS traffic will be tunnelled without recording", "dir", caDir, "err", err)
+			} else {
+				opts = append(opts, proxy.WithCA(ca))
+			}
+		}
+
+		recorder, err := proxy.NewRecorder(targetURL, "./recordings", opts...)
 		if err != nil {
 			slog.Error("failed to create recorder", "err", err)
 			return
 		}
-		slog.Info("proxy recording", "port", port, "target", target)
+		slog.Info("proxy recording", "port", port, "target", targetURL, "forward", forward)
 
 		stop := make(chan os.Signal, 1)
 		signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
@@ -50,4 +83,7 @@ func init() {
 	rootCmd.AddCommand(recordCmd)
 	recordCmd.Flags().IntP("port", "p", 9000, "Port to run proxy on")
 	recordCmd.Flags().IntP("target", "t", 8080, "Target backend URL")
+	recordCmd.Flags().Bool("forward", false, "Run as a forward proxy for clients using HTTP_PROXY/HTTPS_PROXY")
+	recordCmd.Flags().String("ca-dir", defaultCADir(), "Directory holding the CA created by testgen ca init")
+	addRedactFlags(recordCmd)
 }
@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/muzzii255/testgen/proxy"
+
+	"github.com/spf13/cobra"
+)
+
+var recordingsCmd = &cobra.Command{
+	Use:   "recordings",
+	Short: "Manage recorded traffic",
+}
+
+var compactCmd = &cobra.Command{
+	Use:   "compact [journal.jsonl...]",
+	Short: "Fold recording journals into per-endpoint JSON files",
+	Long: `The recorder appends every exchange to a JSONL journal while it runs and
+removes it after a clean shutdown. A journal left behind by a crash or a
+killed process can be turned into regular recording files with this command.
+Without arguments every journal in the recordings directory is compacted,
+except the ones a running recorder still writes to.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		dir, _ := cmd.Flags().GetString("dir")
+		keep, _ := cmd.Flags().GetBool("keep")
+
+		templater, err := loadTemplater(cmd)
+		if err != nil {
+			slog.Error("failed to load path rules", "err", err)
+			return
+		}
+
+		journals := args
+		if len(journals) == 0 {
+			found, err := filepath.Glob(filepath.Join(dir, "journal-*.jsonl"))
+			if err != nil {
+				slog.Error("error listing journals", "dir", dir, "err", err)
+				return
+			}
+			for _, journal := range found {
+				inUse, err := proxy.JournalInUse(journal)
+				if err != nil {
+					slog.Error("error checking journal", "journal", journal, "err", err)
+					continue
+				}
+				if inUse {
+					slog.Info("skipping journal of a running recorder", "journal", journal)
+					continue
+				}
+				journals = append(journals, journal)
+			}
+		}
+		if len(journals) == 0 {
+			slog.Info("no journals to compact", "dir", dir)
+			return
+		}
+
+		for _, journal := range journals {
+			recordings, err := proxy.Compact(journal, dir, templater)
+			if err != nil {
+				slog.Error("error compacting journal", "journal", journal, "err", err)
+				continue
+			}
+			// the journal's rows are on disk now, merged into the recording
+			// files of their day
+			slog.Info("journal compacted", "journal", journal, "endpoints", len(recordings))
+			if keep {
+				continue
+			}
+			// the journal was named explicitly, removing it would lose the
+			// exchanges its recorder is still appending
+			if inUse, _ := proxy.JournalInUse(journal); inUse {
+				slog.Warn("keeping journal of a running recorder", "journal", journal)
+				continue
+			}
+			if err := os.Remove(journal); err != nil {
+				slog.Error("error removing journal", "journal", journal, "err", err)
+			}
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(recordingsCmd)
+	recordingsCmd.AddCommand(compactCmd)
+	compactCmd.Flags().StringP("dir", "d", "./recordings", "Recordings directory to read journals from and write files to")
+	compactCmd.Flags().Bool("keep", false, "Keep journals after compacting them")
+}
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/muzzii255/testgen/proxy"
+	"github.com/muzzii255/testgen/replay"
+
+	"github.com/spf13/cobra"
+)
+
+var replayCmd = &cobra.Command{
+	Use:   "replay",
+	Short: "Replay recorded requests against a live target",
+	Long: `Re-sends every recorded request to the target server and reports
+status code and JSON body differences against the recorded responses.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		fileLoc, _ := cmd.Flags().GetString("file")
+		target, _ := cmd.Flags().GetString("target")
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+
+		recordings, err := proxy.ReadRecordings(fileLoc)
+		if err != nil {
+			slog.Error("error reading file", "err", err)
+			os.Exit(1)
+		}
+		replayer, err := replay.NewReplayer(target, &http.Client{Timeout: timeout})
+		if err != nil {
+			slog.Error("failed to create replayer", "err", err)
+			os.Exit(1)
+		}
+
+		failed := 0
+		results := replayer.Replay(recordings)
+		for _, res := range results {
+			if !res.Failed() {
+				fmt.Printf("ok   %s %s\n", res.Method, res.Path)
+				continue
+			}
+			failed++
+			fmt.Printf("FAIL %s %s (%s #%d)\n", res.Method, res.Path, res.Endpoint, res.Index)
+			if res.Err != nil {
+				fmt.Printf("    %v\n", res.Err)
+				continue
+			}
+			if res.WantStatus != res.GotStatus {
+				fmt.Printf("    status: want %d, got %d\n", res.WantStatus, res.GotStatus)
+			}
+			for _, d := range res.Diffs {
+				fmt.Printf("    %s\n", d)
+			}
+		}
+		fmt.Printf("replayed %d requests, %d mismatched\n", len(results), failed)
+		if failed > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(replayCmd)
+	replayCmd.Flags().StringP("file", "f", "", "Path to the recorded JSON file to replay.")
+	replayCmd.Flags().StringP("target", "t", "http://localhost:8080", "Base URL of the server to replay against")
+	replayCmd.Flags().Duration("timeout", 30*time.Second, "Timeout for each replayed request")
+	replayCmd.MarkFlagRequired("file")
+}
@@ -3,6 +3,9 @@ package cmd
 import (
 	"os"
 
+	"github.com/muzzii255/testgen/config"
+	"github.com/muzzii255/testgen/proxy"
+
 	"github.com/spf13/cobra"
 )
 
@@ -27,5 +30,35 @@ func Execute() {
 	}
 }
 
+func loadConfig(cmd *cobra.Command) (*config.Config, error) {
+	path, _ := cmd.Flags().GetString("config")
+	return config.Load(path, cmd.Flags().Changed("config"))
+}
+
+func loadTemplater(cmd *cobra.Command) (*proxy.Templater, error) {
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		return nil, err
+	}
+	return proxy.NewTemplater(cfg.Paths)
+}
+
+// loadRedactor builds the redaction rules of the config file extended by the
+// --redact-header and --redact-field flags of cmd.
+func loadRedactor(cmd *cobra.Command, cfg *config.Config) (*proxy.Redactor, error) {
+	redactHeaders, _ := cmd.Flags().GetStringSlice("redact-header")
+	redactFields, _ := cmd.Flags().GetStringSlice("redact-field")
+	cfg.Redact.Headers = append(cfg.Redact.Headers, redactHeaders...)
+	cfg.Redact.Fields = append(cfg.Redact.Fields, redactFields...)
+	return proxy.NewRedactor(cfg.Redact)
+}
+
+// addRedactFlags registers the flags read by loadRedactor.
+func addRedactFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSlice("redact-header", nil, "Extra header to redact, Authorization and cookies are always redacted")
+	cmd.Flags().StringSlice("redact-field", nil, "JSON body field to redact, by key name or JSONPath like $.card.number")
+}
+
 func init() {
+	rootCmd.PersistentFlags().String("config", config.DefaultFile, "Path to the testgen config file")
 }
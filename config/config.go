@@ -0,0 +1,39 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/muzzii255/testgen/generator"
+	"github.com/muzzii255/testgen/proxy"
+)
+
+const DefaultFile = "testgen.json"
+
+// Config holds the settings read from testgen.json. Every section is
+// optional, command line flags are merged on top of it by each command.
+type Config struct {
+	Redact proxy.RedactRules     `json:"redact"`
+	Paths  proxy.PathRules       `json:"paths"`
+	Assert generator.AssertRules `json:"assert"`
+}
+
+// Load reads the config file at path. A missing file is only an error when
+// the caller asked for a specific file.
+func Load(path string, required bool) (*Config, error) {
+	cfg := &Config{}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) && !required {
+			return cfg, nil
+		}
+		return nil, fmt.Errorf("reading config %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("parsing config %s: %w", path, err)
+	}
+	return cfg, nil
+}
@@ -0,0 +1,41 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	missing := filepath.Join(dir, "missing.json")
+
+	cfg, err := Load(missing, false)
+	require.NoError(t, err)
+	require.Empty(t, cfg.Redact.Fields)
+
+	_, err = Load(missing, true)
+	require.Error(t, err)
+
+	path := filepath.Join(dir, "testgen.json")
+	err = os.WriteFile(path, []byte(`{"redact":{"headers":["X-Api-Key"],"fields":["password","$.card.number"]}}`), 0o644)
+	require.NoError(t, err)
+	cfg, err = Load(path, false)
+	require.NoError(t, err)
+	require.Equal(t, []string{"X-Api-Key"}, cfg.Redact.Headers)
+	require.Equal(t, []string{"password", "$.card.number"}, cfg.Redact.Fields)
+
+	err = os.WriteFile(path, []byte(`{"assert":{"ignore":["etag"],"typeOnly":["$.meta.total"]}}`), 0o644)
+	require.NoError(t, err)
+	cfg, err = Load(path, false)
+	require.NoError(t, err)
+	require.Equal(t, []string{"etag"}, cfg.Assert.Ignore)
+	require.Equal(t, []string{"$.meta.total"}, cfg.Assert.TypeOnly)
+
+	err = os.WriteFile(path, []byte(`{`), 0o644)
+	require.NoError(t, err)
+	_, err = Load(path, false)
+	require.Error(t, err)
+}
@@ -0,0 +1,281 @@
+package generator
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// annotationKeys are the keys understood in a @testgen annotation.
+var annotationKeys = map[string]bool{
+	"router":   true,
+	"struct":   true,
+	"method":   true,
+	"request":  true,
+	"response": true,
+	"status":   true,
+	"patch":    true,
+}
+
+// Model is a struct referenced by an annotation, either as StructName for the
+// scanned root package or as pkg.StructName.
+type Model struct {
+	Folder string
+	Struct string
+	Name   string
+}
+
+func newModel(name string) *Model {
+	if name == "" {
+		return nil
+	}
+	folder, strct := splitStruct(name)
+	return &Model{Folder: folder, Struct: strct, Name: name}
+}
+
+// MethodModels are the structs annotated for one method of a router.
+type MethodModels struct {
+	Router string
+	// Method is empty for routes discovered without a method, which match
+	// every method.
+	Method   string
+	Request  *Model
+	Response *Model
+	// Status is the status code the response struct applies to, 0 for any
+	// successful status.
+	Status int
+}
+
+// Route collects every annotation written for routers sharing an endpoint,
+// such as /users and /users/:id.
+type Route struct {
+	Router string
+	// Default is the struct= model, used for requests of methods without
+	// their own request struct.
+	Default *Model
+	// Patch is the struct PATCH bodies are mapped onto. Without it PATCH
+	// bodies become partial versions of Default.
+	Patch   *Model
+	Methods []*MethodModels
+}
+
+// matchRouter reports whether a templated request path fits router. Route
+// parameters match any segment.
+func matchRouter(router, path string) bool {
+	want, got := splitSegments(router), splitSegments(path)
+	if len(want) != len(got) {
+		return false
+	}
+	for i, seg := range want {
+		if strings.HasPrefix(seg, ":") || strings.HasPrefix(seg, "{") {
+			continue
+		}
+		if seg != got[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *Route) method(method, path string) *MethodModels {
+	if r == nil {
+		return nil
+	}
+	for _, m := range r.Methods {
+		if (m.Method == method || m.Method == "") && matchRouter(m.Router, path) {
+			return m
+		}
+	}
+	return nil
+}
+
+// RequestModel returns the struct request bodies of method on path are
+// mapped onto. partial reports that only the recorded fields of it should be
+// sent.
+func (r *Route) RequestModel(method, path string) (model *Model, partial bool) {
+	if r == nil {
+		return nil, false
+	}
+	for _, m := range r.Methods {
+		if m.Request != nil && (m.Method == method || m.Method == "") && matchRouter(m.Router, path) {
+			return m.Request, false
+		}
+	}
+	if method == "PATCH" {
+		if r.Patch != nil {
+			return r.Patch, false
+		}
+		return r.Default, r.Default != nil
+	}
+	return r.Default, false
+}
+
+// ResponseModel returns the struct responses of method on path with the
+// given status are decoded into, or nil.
+func (r *Route) ResponseModel(method, path string, status int) *Model {
+	m := r.method(method, path)
+	if m == nil || m.Response == nil {
+		return nil
+	}
+	if m.Status != 0 && m.Status != status {
+		return nil
+	}
+	if m.Status == 0 && (status < 200 || status > 299) {
+		return nil
+	}
+	return m.Response
+}
+
+type annotation struct {
+	router   string
+	strct    string
+	method   string
+	request  string
+	response string
+	status   int
+	patch    string
+	// attached is the type declared below the annotation, used when neither
+	// struct= nor request= is given.
+	attached *Model
+}
+
+func (a annotation) requestModel() *Model {
+	if a.strct != "" || a.request != "" {
+		return newModel(a.strct + a.request)
+	}
+	return a.attached
+}
+
+// annotationFields splits the key=value pairs of an annotation on spaces and
+// tabs. Values may be double quoted.
+func annotationFields(str string) ([]string, error) {
+	fields := make([]string, 0)
+	for str = strings.TrimSpace(str); str != ""; str = strings.TrimLeft(str, " \t") {
+		end := strings.IndexAny(str, " \t")
+		if end < 0 {
+			end = len(str)
+		}
+		field := str[:end]
+		if key, value, ok := strings.Cut(field, "="); ok && strings.HasPrefix(value, `"`) {
+			quoted, err := strconv.QuotedPrefix(str[len(key)+1:])
+			if err != nil {
+				return nil, fmt.Errorf("unterminated quoted value for %q", key)
+			}
+			value, _ = strconv.Unquote(quoted)
+			field = key + "=" + value
+			end = len(key) + 1 + len(quoted)
+		}
+		fields = append(fields, field)
+		str = str[end:]
+	}
+	return fields, nil
+}
+
+// parseAnnotation reads the key=value pairs of a @testgen comment line.
+// attached is the type the annotation documents, if any.
+func parseAnnotation(str string, attached *Model) (annotation, error) {
+	a := annotation{attached: attached}
+	if i := strings.Index(str, "@testgen"); i >= 0 {
+		str = str[i+len("@testgen"):]
+	}
+	fields, err := annotationFields(str)
+	if err != nil {
+		return annotation{}, err
+	}
+	for _, field := range fields {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok || value == "" {
+			return annotation{}, fmt.Errorf("malformed annotation field %q", field)
+		}
+		if !annotationKeys[key] {
+			return annotation{}, fmt.Errorf("unknown annotation key %q", key)
+		}
+		switch key {
+		case "router":
+			a.router = value
+		case "struct":
+			a.strct = value
+		case "method":
+			a.method = strings.ToUpper(value)
+		case "request":
+			a.request = value
+		case "response":
+			a.response = value
+		case "patch":
+			a.patch = value
+		case "status":
+			status, err := strconv.Atoi(value)
+			if err != nil || status < 100 || status > 599 {
+				return annotation{}, fmt.Errorf("invalid status %q", value)
+			}
+			a.status = status
+		}
+	}
+	if a.router == "" {
+		return annotation{}, errors.New("missing router key")
+	}
+	if a.strct != "" && a.request != "" {
+		return annotation{}, errors.New("struct and request name the same body, use one of them")
+	}
+	if a.requestModel() == nil && a.response == "" && a.patch == "" {
+		return annotation{}, errors.New("missing struct, request or response key and no type declared below")
+	}
+	if a.method == "" && (a.response != "" || a.status != 0) {
+		return annotation{}, errors.New("response and status need a method key")
+	}
+	return a, nil
+}
+
+// routeKey drops :name and {name} segments from a router so that annotations
+// written with route parameters match the endpoint recordings are grouped by.
+func routeKey(router string) string {
+	segments := make([]string, 0)
+	for _, seg := range splitSegments(router) {
+		if strings.HasPrefix(seg, ":") || strings.HasPrefix(seg, "{") {
+			continue
+		}
+		segments = append(segments, seg)
+	}
+	return "/" + strings.Join(segments, "/")
+}
+
+// addAnnotation merges an annotation into the routes, keyed by endpoint.
+func addAnnotation(routes map[string]*Route, a annotation) error {
+	key := routeKey(a.router)
+	route, ok := routes[key]
+	if !ok {
+		route = &Route{Router: a.router}
+		routes[key] = route
+	}
+	model := a.requestModel()
+	if a.patch != "" && route.Patch != nil {
+		return fmt.Errorf("duplicate patch struct for router %s", a.router)
+	}
+	if a.method == "" && model != nil && route.Default != nil {
+		return fmt.Errorf("duplicate struct for router %s", a.router)
+	}
+	for _, m := range route.Methods {
+		if a.method != "" && m.Method == a.method && m.Router == a.router {
+			return fmt.Errorf("duplicate %s annotation for router %s", a.method, a.router)
+		}
+	}
+
+	if a.patch != "" {
+		route.Patch = newModel(a.patch)
+	}
+	if a.method == "" {
+		if model != nil {
+			route.Default = model
+		}
+		return nil
+	}
+	route.Methods = append(route.Methods, &MethodModels{
+		Router:   a.router,
+		Method:   a.method,
+		Request:  model,
+		Response: newModel(a.response),
+		Status:   a.status,
+	})
+	return nil
+}
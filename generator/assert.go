@@ -0,0 +1,204 @@
+package generator
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/muzzii255/testgen/proxy"
+)
+
+// Matchers written in place of recorded values the generated requireJSON
+// helper only checks loosely.
+const (
+	anyMatcher    = "<any>"
+	stringMatcher = "<string>"
+	numberMatcher = "<number>"
+	boolMatcher   = "<bool>"
+	objectMatcher = "<object>"
+	arrayMatcher  = "<array>"
+)
+
+var uuidRe = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// AssertRules selects the response fields generated tests don't compare
+// with the recording. Fields match either by key name at any depth ("etag")
+// or by JSONPath from the document root ("$.meta.request_id"). Array
+// elements share the path of their array.
+type AssertRules struct {
+	// Ignore lists fields that may hold any value or be missing.
+	Ignore []string `json:"ignore"`
+	// TypeOnly lists fields that only need to keep their JSON type, on top
+	// of the volatile fields detected by key name and value.
+	TypeOnly []string `json:"typeOnly"`
+}
+
+type fieldSet struct {
+	keys  map[string]bool
+	paths map[string]bool
+}
+
+func newFieldSet(fields []string) (fieldSet, error) {
+	set := fieldSet{keys: make(map[string]bool), paths: make(map[string]bool)}
+	for _, f := range fields {
+		f = strings.TrimSpace(f)
+		if !strings.HasPrefix(f, "$") {
+			if f == "" {
+				return set, fmt.Errorf("empty assertion field")
+			}
+			set.keys[f] = true
+			continue
+		}
+		parts := strings.Split(strings.TrimPrefix(f, "$"), ".")
+		if len(parts) < 2 || parts[0] != "" || slices.Contains(parts[1:], "") {
+			return set, fmt.Errorf("invalid assertion path %q, expected $.key or $.key.nested", f)
+		}
+		set.paths[strings.Join(parts[1:], ".")] = true
+	}
+	return set, nil
+}
+
+func (s fieldSet) match(path []string) bool {
+	return s.keys[path[len(path)-1]] || s.paths[strings.Join(path, ".")]
+}
+
+// bodyMatcher turns recorded response bodies into the expected JSON of
+// generated body assertions.
+type bodyMatcher struct {
+	ignore   fieldSet
+	typeOnly fieldSet
+}
+
+func newBodyMatcher(rules AssertRules) (*bodyMatcher, error) {
+	ignore, err := newFieldSet(rules.Ignore)
+	if err != nil {
+		return nil, err
+	}
+	typeOnly, err := newFieldSet(rules.TypeOnly)
+	if err != nil {
+		return nil, err
+	}
+	return &bodyMatcher{ignore: ignore, typeOnly: typeOnly}, nil
+}
+
+// volatileKey reports whether a field name usually holds a value that
+// changes between runs, such as IDs and timestamps.
+func volatileKey(key string) bool {
+	lower := strings.ToLower(key)
+	switch {
+	case lower == "id", lower == "uuid", lower == "timestamp":
+		return true
+	case strings.HasSuffix(lower, "_id"), strings.HasSuffix(key, "Id"), strings.HasSuffix(key, "ID"):
+		return true
+	case strings.HasSuffix(lower, "_at"), strings.HasSuffix(key, "At"):
+		return true
+	}
+	return false
+}
+
+// volatileValue reports whether a string is a timestamp, a UUID or a
+// redacted secret, which differ between runs whatever the field is called.
+func volatileValue(s string) bool {
+	if _, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return true
+	}
+	if _, ok := proxy.ParsePlaceholder(s); ok {
+		return true
+	}
+	return uuidRe.MatchString(s)
+}
+
+func typeMatcher(v any) string {
+	switch v.(type) {
+	case string:
+		return stringMatcher
+	case json.Number:
+		return numberMatcher
+	case bool:
+		return boolMatcher
+	case map[string]any:
+		return objectMatcher
+	case []any:
+		return arrayMatcher
+	}
+	return anyMatcher
+}
+
+// expected returns the recorded body with ignored and volatile values
+// replaced by matchers, or false when the body is not JSON.
+func (m *bodyMatcher) expected(body string) (string, bool) {
+	dec := json.NewDecoder(strings.NewReader(body))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return "", false
+	}
+	doc = m.replace(doc, nil)
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(doc); err != nil {
+		return "", false
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), true
+}
+
+func (m *bodyMatcher) replace(v any, path []string) any {
+	switch t := v.(type) {
+	case map[string]any:
+		for k, fv := range t {
+			t[k] = m.field(k, fv, append(slices.Clip(path), k))
+		}
+	case []any:
+		for i, e := range t {
+			if s, ok := e.(string); ok && volatileValue(s) {
+				t[i] = stringMatcher
+				continue
+			}
+			t[i] = m.replace(e, path)
+		}
+	}
+	return v
+}
+
+func (m *bodyMatcher) field(key string, v any, path []string) any {
+	switch {
+	case m.ignore.match(path):
+		return anyMatcher
+	case m.typeOnly.match(path) && v != nil:
+		return typeMatcher(v)
+	}
+	switch t := v.(type) {
+	case map[string]any, []any:
+		return m.replace(t, path)
+	case string:
+		if volatileKey(key) || volatileValue(t) {
+			return stringMatcher
+		}
+	case json.Number:
+		if volatileKey(key) {
+			return numberMatcher
+		}
+	case nil:
+		// a volatile field recorded as null, such as an unset parent_id or
+		// expires_at, may hold a value on the next run
+		if volatileKey(key) {
+			return anyMatcher
+		}
+	}
+	return v
+}
+
+// bodyLiteral quotes the expected JSON, as a raw string when possible.
+func bodyLiteral(expected string) string {
+	if strings.Contains(expected, "`") {
+		return strconv.Quote(expected)
+	}
+	return "`" + expected + "`"
+}
@@ -0,0 +1,333 @@
+package generator
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/muzzii255/testgen/proxy"
+)
+
+// chainVar is a value captured from a create response and reused in the
+// paths of later requests.
+type chainVar struct {
+	name  string
+	field []string
+	str   bool
+}
+
+// idLeaf is a scalar found in a response body, addressed by the object keys
+// leading to it.
+type idLeaf struct {
+	field []string
+	value string
+	str   bool
+}
+
+// chainPlan records which rows capture values from their response and which
+// rows use them in their path or request body.
+type chainPlan struct {
+	vars     []chainVar
+	captures map[*proxy.BodyRecords][]chainVar
+	subst    map[*proxy.BodyRecords]map[string]string
+	// fields maps the dotted JSON paths of request body fields to the
+	// variables replacing their recorded value.
+	fields map[*proxy.BodyRecords]map[string]string
+}
+
+// planChain looks for values returned by POST requests that later requests
+// use as path parameters or as ID fields of their body. rows must be in the
+// order the generated test sends them, endpoints holds the endpoint each row
+// belongs to.
+func planChain(rows []*proxy.BodyRecords, endpoints []string) *chainPlan {
+	plan := &chainPlan{
+		captures: make(map[*proxy.BodyRecords][]chainVar),
+		subst:    make(map[*proxy.BodyRecords]map[string]string),
+		fields:   make(map[*proxy.BodyRecords]map[string]string),
+	}
+	type source struct {
+		row      *proxy.BodyRecords
+		endpoint string
+		leaf     idLeaf
+	}
+	sources := make(map[string]source)
+	captured := make(map[string]chainVar)
+	names := make(map[string]bool)
+	capture := func(src source) chainVar {
+		key := fmt.Sprintf("%p.%s", src.row, strings.Join(src.leaf.field, "."))
+		v, ok := captured[key]
+		if !ok {
+			v = chainVar{
+				name:  uniqueName(names, varName(src.endpoint, src.leaf.field)),
+				field: src.leaf.field,
+				str:   src.leaf.str,
+			}
+			captured[key] = v
+			plan.vars = append(plan.vars, v)
+			plan.captures[src.row] = append(plan.captures[src.row], v)
+		}
+		return v
+	}
+
+	for i, row := range rows {
+		// the cases of a table are built before the first one runs, so rows
+		// sharing a table with their source keep the recorded values
+		sameTable := func(src source) bool {
+			return src.endpoint == endpoints[i] && src.row.Method == row.Method
+		}
+		for _, p := range pathParams(row) {
+			src, ok := sources[p.Value]
+			if !ok || sameTable(src) {
+				continue
+			}
+			if plan.subst[row] == nil {
+				plan.subst[row] = make(map[string]string)
+			}
+			plan.subst[row][p.Value] = capture(src).name
+		}
+		for _, leaf := range idLeaves(row.Body) {
+			src, ok := sources[leaf.value]
+			if !ok || !isIDKey(leaf.field[len(leaf.field)-1]) || sameTable(src) {
+				continue
+			}
+			if plan.fields[row] == nil {
+				plan.fields[row] = make(map[string]string)
+			}
+			plan.fields[row][strings.Join(leaf.field, ".")] = capture(src).name
+		}
+
+		if row.Method != "POST" {
+			continue
+		}
+		seen := make(map[string]bool)
+		for _, leaf := range idLeaves(row.ResponseBody) {
+			if seen[leaf.value] {
+				continue
+			}
+			seen[leaf.value] = true
+			sources[leaf.value] = source{row: row, endpoint: endpoints[i], leaf: leaf}
+		}
+	}
+	return plan
+}
+
+// bodyVars returns the variables replacing request body fields of row, by
+// dotted JSON path.
+func (p *chainPlan) bodyVars(row *proxy.BodyRecords) map[string]string {
+	if p == nil {
+		return nil
+	}
+	return p.fields[row]
+}
+
+func isIDKey(key string) bool {
+	return strings.HasSuffix(strings.ToLower(key), "id")
+}
+
+// pathParams returns the path parameters of row, derived from its raw path
+// with the built-in templating for recordings that don't store them.
+func pathParams(row *proxy.BodyRecords) []proxy.PathParam {
+	if len(row.PathParams) > 0 || row.RawPath == "" {
+		return row.PathParams
+	}
+	var templater *proxy.Templater
+	_, _, params := templater.Template(row.RawPath)
+	return params
+}
+
+// templatePath returns the templated path of row, such as /users/:id.
+func templatePath(row *proxy.BodyRecords) string {
+	if row.Path != "" || row.RawPath == "" {
+		return row.Path
+	}
+	var templater *proxy.Templater
+	_, path, _ := templater.Template(row.RawPath)
+	return path
+}
+
+// idLeaves returns the strings of a JSON object, outside of arrays, and the
+// numbers stored under keys that look like IDs. Small numbers such as counts
+// would otherwise be mistaken for IDs. Keys that look like IDs come first,
+// then shallower fields.
+func idLeaves(body string) []idLeaf {
+	dec := json.NewDecoder(strings.NewReader(body))
+	dec.UseNumber()
+	var doc map[string]any
+	if err := dec.Decode(&doc); err != nil {
+		return nil
+	}
+	leaves := make([]idLeaf, 0)
+	var walk func(obj map[string]any, field []string)
+	walk = func(obj map[string]any, field []string) {
+		for k, v := range obj {
+			path := append(slices.Clone(field), k)
+			switch t := v.(type) {
+			case string:
+				if t != "" {
+					leaves = append(leaves, idLeaf{field: path, value: t, str: true})
+				}
+			case json.Number:
+				if isIDKey(k) {
+					leaves = append(leaves, idLeaf{field: path, value: t.String()})
+				}
+			case map[string]any:
+				walk(t, path)
+			}
+		}
+	}
+	walk(doc, nil)
+
+	rank := func(l idLeaf) int {
+		key := l.field[len(l.field)-1]
+		switch {
+		case strings.EqualFold(key, "id"):
+			return 0
+		case isIDKey(key):
+			return 1
+		}
+		return 2
+	}
+	slices.SortFunc(leaves, func(a, b idLeaf) int {
+		if r := rank(a) - rank(b); r != 0 {
+			return r
+		}
+		if d := len(a.field) - len(b.field); d != 0 {
+			return d
+		}
+		return strings.Compare(strings.Join(a.field, "."), strings.Join(b.field, "."))
+	})
+	return leaves
+}
+
+// exportName turns a JSON key into an exported Go identifier, user_id and
+// userId both become UserID.
+func exportName(key string) string {
+	parts := strings.FieldsFunc(key, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	var sb strings.Builder
+	for _, p := range parts {
+		if strings.EqualFold(p, "id") {
+			sb.WriteString("ID")
+			continue
+		}
+		if strings.HasSuffix(p, "Id") {
+			p = strings.TrimSuffix(p, "Id") + "ID"
+		}
+		r := []rune(p)
+		r[0] = unicode.ToUpper(r[0])
+		sb.WriteString(string(r))
+	}
+	name := sb.String()
+	if name == "" || unicode.IsDigit([]rune(name)[0]) {
+		name = "F" + name
+	}
+	return name
+}
+
+func varName(endpoint string, field []string) string {
+	prefix := []rune(exportName(getFuncName(endpoint)))
+	prefix[0] = unicode.ToLower(prefix[0])
+	return string(prefix) + exportName(field[len(field)-1])
+}
+
+func uniqueName(names map[string]bool, name string) string {
+	unique := name
+	for i := 2; names[unique]; i++ {
+		unique = fmt.Sprintf("%s%d", name, i)
+	}
+	names[unique] = true
+	return unique
+}
+
+// declarations declares the captured variables at the top of a test function.
+func (p *chainPlan) declarations() string {
+	if p == nil {
+		return ""
+	}
+	var sb strings.Builder
+	for _, v := range p.vars {
+		fmt.Fprintf(&sb, "var %s string\n", v.name)
+	}
+	if len(p.vars) > 0 {
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+type captureNode struct {
+	key      string
+	name     string
+	leaf     *chainVar
+	children []*captureNode
+}
+
+func (n *captureNode) child(key string) *captureNode {
+	for _, c := range n.children {
+		if c.key == key {
+			return c
+		}
+	}
+	c := &captureNode{key: key, name: exportName(key)}
+	for slices.ContainsFunc(n.children, func(o *captureNode) bool { return o.name == c.name }) {
+		c.name += "_"
+	}
+	n.children = append(n.children, c)
+	return c
+}
+
+func (n *captureNode) writeType(sb *strings.Builder) {
+	sb.WriteString("struct {\n")
+	for _, c := range n.children {
+		sb.WriteString(c.name + " ")
+		switch {
+		case c.leaf == nil:
+			c.writeType(sb)
+		case c.leaf.str:
+			sb.WriteString("string")
+		default:
+			sb.WriteString("json.Number")
+		}
+		fmt.Fprintf(sb, " `json:%q`\n", c.key)
+	}
+	sb.WriteString("}")
+}
+
+// captureCode decodes the response of row and stores the values later rows
+// need, or returns an empty string when nothing is captured.
+func (j *JsonFile) captureCode(row *proxy.BodyRecords) string {
+	typ, assigns := j.captureParts(row)
+	if typ == "" {
+		return ""
+	}
+	return "created, _ := decodeResp[" + typ + "](t, resp)\n" + assigns
+}
+
+// captureParts returns the struct type holding the captured values of row and
+// the assignments copying them out of a decoded created variable.
+func (j *JsonFile) captureParts(row *proxy.BodyRecords) (string, string) {
+	if j.chain == nil || len(j.chain.captures[row]) == 0 {
+		return "", ""
+	}
+	root := &captureNode{}
+	var assigns strings.Builder
+	for _, v := range j.chain.captures[row] {
+		node := root
+		names := make([]string, 0, len(v.field))
+		for _, key := range v.field {
+			node = node.child(key)
+			names = append(names, node.name)
+		}
+		node.leaf = &v
+		expr := "created." + strings.Join(names, ".")
+		if !v.str {
+			expr += ".String()"
+		}
+		fmt.Fprintf(&assigns, "%s = %s\n", v.name, expr)
+	}
+	var sb strings.Builder
+	root.writeType(&sb)
+	return sb.String(), assigns.String()
+}
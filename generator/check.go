@@ -0,0 +1,226 @@
+package generator
+
+import (
+	"cmp"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/muzzii255/testgen/proxy"
+	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/tools/go/packages"
+)
+
+// origin is the recorded exchange a generated case was written from.
+type origin struct {
+	endpoint string
+	method   string
+	path     string
+	// row is the index of the exchange in the bodyRecords of its endpoint,
+	// or in the timeline for scenarios. It is -1 when unknown.
+	row int
+}
+
+func (j *JsonFile) originKey(fn, label string) string {
+	return strings.ToLower(fn) + "/" + label
+}
+
+// addOrigin records the exchange behind the case label of the function being
+// generated.
+func (j *JsonFile) addOrigin(label, endpoint string, row *proxy.BodyRecords) {
+	idx, ok := j.rows[row]
+	if !ok {
+		idx = -1
+	}
+	if j.origins == nil {
+		j.origins = make(map[string]origin)
+	}
+	j.origins[j.originKey("test"+j.testName, label)] = origin{endpoint: endpoint, method: row.Method, path: row.Path, row: idx}
+}
+
+// aliasOrigin makes label refer to the exchange of the case named name, for
+// subtests that are labelled differently from their case.
+func (j *JsonFile) aliasOrigin(label, name string) {
+	if o, ok := j.origins[j.originKey("test"+j.testName, name)]; ok {
+		j.origins[j.originKey("test"+j.testName, label)] = o
+	}
+}
+
+func (j *JsonFile) describe(o origin) string {
+	var where string
+	switch {
+	case o.row < 0:
+		where = j.Filename
+	case j.Scenario:
+		where = fmt.Sprintf("%s:%d", j.Filename, o.row+1)
+	default:
+		where = fmt.Sprintf("%s: %s bodyRecords[%d]", j.Filename, o.endpoint, o.row)
+	}
+	return fmt.Sprintf("recorded %s %s in %s", o.method, o.path, where)
+}
+
+// checkFiles type checks the gentest package with files, sources by file
+// name, in place of the files on disk. Compile errors are returned as
+// Diagnostics naming the recorded exchange and struct field the failing code
+// was generated from.
+func (j *JsonFile) checkFiles(files map[string][]byte) error {
+	dir, err := filepath.Abs(testFileDir)
+	if err != nil {
+		return err
+	}
+	overlay := make(map[string][]byte, len(files))
+	for name, src := range files {
+		overlay[filepath.Join(dir, name)] = src
+	}
+	// dependencies are type checked from source, see DiscoverRoutes
+	cfg := &packages.Config{
+		Mode: packages.NeedName | packages.NeedFiles | packages.NeedSyntax |
+			packages.NeedImports | packages.NeedDeps | packages.NeedTypes | packages.NeedTypesInfo,
+		Dir:     dir,
+		Tests:   true,
+		Overlay: overlay,
+	}
+	pkgs, err := packages.Load(cfg, ".")
+	if err != nil {
+		slog.Warn("error loading generated tests, skipping type check", "err", err)
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	var diags Diagnostics
+	add := func(pos token.Position, msg string) {
+		// positions are reported relative to the working directory, like
+		// the gentest directory itself
+		if rel, err := filepath.Rel(filepath.Dir(dir), pos.Filename); err == nil && !strings.HasPrefix(rel, "..") {
+			pos.Filename = rel
+		}
+		if key := pos.String() + msg; !seen[key] {
+			seen[key] = true
+			diags = append(diags, Diagnostic{Pos: pos, Msg: msg})
+		}
+	}
+	for _, pkg := range pkgs {
+		for _, e := range pkg.Errors {
+			if e.Kind != packages.TypeError {
+				add(token.Position{Filename: e.Pos}, e.Msg)
+			}
+		}
+		for _, e := range pkg.TypeErrors {
+			pos := e.Fset.Position(e.Pos)
+			msg := e.Msg
+			if _, ok := files[filepath.Base(pos.Filename)]; ok && filepath.Dir(pos.Filename) == dir {
+				msg += j.errorContext(pkg, e.Pos)
+			}
+			add(pos, msg)
+		}
+	}
+	if len(diags) == 0 {
+		return nil
+	}
+	slices.SortStableFunc(diags, func(a, b Diagnostic) int {
+		return cmp.Or(
+			strings.Compare(a.Pos.Filename, b.Pos.Filename),
+			cmp.Compare(a.Pos.Line, b.Pos.Line),
+			cmp.Compare(a.Pos.Column, b.Pos.Column),
+		)
+	})
+	return diags
+}
+
+// errorContext describes the recorded exchange and the struct field of the
+// generated code at pos.
+func (j *JsonFile) errorContext(pkg *packages.Package, pos token.Pos) string {
+	var file *ast.File
+	for _, f := range pkg.Syntax {
+		if f.FileStart <= pos && pos <= f.FileEnd {
+			file = f
+		}
+	}
+	if file == nil {
+		return ""
+	}
+	path, _ := astutil.PathEnclosingInterval(file, pos, pos)
+	var field, label, fn string
+	for i, n := range path {
+		switch n := n.(type) {
+		case *ast.KeyValueExpr:
+			key, ok := n.Key.(*ast.Ident)
+			lit, isLit := path[min(i+1, len(path)-1)].(*ast.CompositeLit)
+			if field != "" || label != "" || !ok || !isLit || caseLabel(lit) != "" {
+				continue
+			}
+			field = key.Name
+			if named, ok := types.Unalias(pkg.TypesInfo.TypeOf(lit)).(*types.Named); ok {
+				field = types.TypeString(named, func(p *types.Package) string {
+					if p == pkg.Types {
+						return ""
+					}
+					return p.Name()
+				}) + "." + field
+			}
+		case *ast.CompositeLit:
+			if label == "" {
+				label = caseLabel(n)
+			}
+		case *ast.CallExpr:
+			if label == "" {
+				label = runLabel(n)
+			}
+		case *ast.FuncDecl:
+			fn = n.Name.Name
+		}
+	}
+
+	parts := make([]string, 0, 2)
+	if o, ok := j.origins[j.originKey(fn, label)]; ok && label != "" {
+		parts = append(parts, j.describe(o))
+	}
+	if field != "" {
+		parts = append(parts, "field "+field)
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(parts, ", ") + ")"
+}
+
+// caseLabel returns the name of a table driven case literal.
+func caseLabel(lit *ast.CompositeLit) string {
+	for _, elt := range lit.Elts {
+		kv, ok := elt.(*ast.KeyValueExpr)
+		if !ok {
+			continue
+		}
+		if key, ok := kv.Key.(*ast.Ident); ok && key.Name == "name" {
+			return stringLit(kv.Value)
+		}
+	}
+	return ""
+}
+
+// runLabel returns the name of a t.Run subtest.
+func runLabel(call *ast.CallExpr) string {
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok || sel.Sel.Name != "Run" || len(call.Args) != 2 {
+		return ""
+	}
+	return stringLit(call.Args[0])
+}
+
+func stringLit(e ast.Expr) string {
+	lit, ok := e.(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return ""
+	}
+	s, err := strconv.Unquote(lit.Value)
+	if err != nil {
+		return ""
+	}
+	return s
+}
@@ -2,12 +2,18 @@ package generator
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"go/format"
 	"io"
 	"log/slog"
+	"maps"
+	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/muzzii255/testgen/proxy"
 	"github.com/muzzii255/testgen/structgen"
@@ -40,10 +46,52 @@ var statusMap = map[int]string{
 }
 
 type JsonFile struct {
-	Filename   string
-	BaseDir    string
-	models     map[string]map[string]string
+	Filename string
+	BaseDir  string
+	// Scenario reads Filename as a recorded timeline and generates one test
+	// per user flow instead of one test per endpoint.
+	Scenario bool
+	// ScenarioGap splits the requests of a client into separate flows when
+	// they are further apart than this.
+	ScenarioGap time.Duration
+	// Discover reads the routes registered in the router setup code under
+	// BaseDir in addition to the @testgen annotations.
+	Discover bool
+	// Framework selects the harness written to main_test.go and testgen_helpers.go,
+	// one of Frameworks. It is detected from go.mod when empty.
+	Framework string
+	// External writes a harness sending requests to a running server at
+	// TESTGEN_BASE_URL instead of an in-process app.
+	External bool
+	// Assert selects the response fields that are not compared with the
+	// recorded body.
+	Assert AssertRules
+	// Golden writes the expected response bodies to golden files under
+	// gentest/testdata instead of inlining them in the tests.
+	Golden bool
+	// UpdateGolden rewrites existing golden files from the recording, they
+	// are only created when missing otherwise.
+	UpdateGolden bool
+	// Check type checks the generated tests with the module before writing
+	// them and fails with Diagnostics when they don't compile.
+	Check      bool
+	matcher    *bodyMatcher
+	goldens    map[string]string
+	testName   string
+	routes     map[string]*Route
 	recordings map[string]proxy.Recording
+	timeline   []proxy.TimelineEntry
+	chain      *chainPlan
+	// rows holds the index of the exchanges of the function being generated
+	// in their recording, origins the exchange of each generated case.
+	rows    map[*proxy.BodyRecords]int
+	origins map[string]origin
+	// inferred holds the request structs inferred from recorded bodies, by
+	// endpoint and method, for endpoints without a known struct.
+	inferred  map[string]*inferredType
+	typeNames map[string]bool
+	// typeOrigins holds the origin of the types already in types_gen.go.
+	typeOrigins map[string]string
 }
 
 func (j *JsonFile) ReadFile() error {
@@ -56,16 +104,22 @@ func (j *JsonFile) ReadFile() error {
 		return fmt.Errorf("error reading file %s :%v", j.Filename, err)
 	}
 
-	if err := json.Unmarshal(data, &j.recordings); err != nil {
+	if j.Scenario {
+		timeline, err := proxy.ReadTimeline(j.Filename)
+		if err != nil {
+			return err
+		}
+		j.timeline = timeline
+	} else if err := json.Unmarshal(data, &j.recordings); err != nil {
 		return fmt.Errorf("error parsing recordings %s :%v", j.Filename, err)
 	}
 
-	scanner := Scanner{InputDir: j.BaseDir}
-	models, err := scanner.ScanTags()
+	scanner := Scanner{InputDir: j.BaseDir, Discover: j.Discover}
+	routes, err := scanner.ScanRoutes()
 	if err != nil {
 		return err
 	}
-	j.models = models
+	j.routes = routes
 	return nil
 }
 
@@ -94,25 +148,43 @@ func getFuncName(endpoint string) string {
 	return strings.Join(nm, "")
 }
 
+// detectFiles writes the harness of the generated tests. main_test.go is
+// only written when missing since it sets up the app, the helpers are
+// rewritten on every run.
 func (j *JsonFile) detectFiles() error {
 	if err := os.MkdirAll(testFileDir, 0o755); err != nil {
 		return err
 	}
-	_, err := os.Stat("./gentest/main_test.go")
-	if err != nil {
-		e := os.WriteFile(filepath.Join(testFileDir, "main_test.go"), []byte(mainTest), 0o644)
-		if e != nil {
-			return e
+	mainTmpl, helpersTmpl := mainTest, helperFile
+	h := harness{AppType: "*http.Client"}
+	if j.External {
+		mainTmpl, helpersTmpl = externalMainTest, externalHelperFile
+	} else {
+		var err error
+		if h, err = j.harness(); err != nil {
+			return err
 		}
 	}
-	_, err = os.Stat("./gentest/testutils.go")
-	if err != nil {
-		e := os.WriteFile(filepath.Join(testFileDir, "testutils.go"), []byte(helperFile), 0o644)
-		if e != nil {
-			return e
+
+	path := filepath.Join(testFileDir, "main_test.go")
+	if _, err := os.Stat(path); err == nil {
+		if app := setupType(path); app != "" && app != h.AppType {
+			slog.Warn("existing harness doesn't match the requested framework or mode, update or delete it", "file", path, "app", app, "want", h.AppType)
+		}
+	} else {
+		src, err := render(mainTmpl, h)
+		if err != nil {
+			return err
+		}
+		formatted, err := format.Source([]byte(src))
+		if err != nil {
+			return fmt.Errorf("error formatting main_test.go :%v", err)
+		}
+		if err := os.WriteFile(path, formatted, 0o644); err != nil {
+			return err
 		}
 	}
-	return nil
+	return writeHelpers(helpersTmpl, h)
 }
 
 func (j *JsonFile) getFileName() string {
@@ -124,250 +196,514 @@ func (j *JsonFile) getFileName() string {
 	}
 }
 
-func (j *JsonFile) genPostRun(endpoint string, rows []proxy.BodyRecords) string {
-	if len(rows) == 0 {
-		return ""
+// skipHeaders lists request headers that are set by the HTTP client or the
+// transport and make no sense to replay from a generated test.
+var skipHeaders = map[string]bool{
+	"Accept-Encoding":   true,
+	"Cache-Control":     true,
+	"Connection":        true,
+	"Content-Length":    true,
+	"Host":              true,
+	"Keep-Alive":        true,
+	"Origin":            true,
+	"Postman-Token":     true,
+	"Pragma":            true,
+	"Proxy-Connection":  true,
+	"Referer":           true,
+	"Te":                true,
+	"Transfer-Encoding": true,
+	"Upgrade":           true,
+	"User-Agent":        true,
+	"X-Forwarded-For":   true,
+}
+
+// assertedHeaders are the response headers checked by generated tests.
+var assertedHeaders = []string{"Allow", "Content-Type", "Location"}
+
+// presentHeaders are the asserted headers only checked to be set, their
+// values hold IDs and hosts that change between runs.
+var presentHeaders = map[string]bool{"Location": true}
+
+// headerCheck is a response header assertion, an empty value only requires
+// the header to be set.
+type headerCheck struct {
+	name  string
+	value string
+}
+
+type testCase struct {
+	name        string
+	path        string
+	payload     string
+	headers     string
+	status      string
+	respHeaders []headerCheck
+	// check decodes the response into its annotated struct and captures the
+	// values later requests need.
+	check string
+}
+
+func statusExpr(code int) string {
+	if sm, ok := statusMap[code]; ok {
+		return sm
 	}
-	pkgPath, ok := j.models[endpoint]["folder"]
-	if !ok {
-		return ""
+	return fmt.Sprintf("%d", code)
+}
+
+// pathExpr returns the Go expression for the request path of a recorded row.
+// Query parameters are rebuilt with url.Values so the generated code stays
+// readable and correctly escaped.
+func (j *JsonFile) pathExpr(path string, row proxy.BodyRecords) string {
+	if len(row.Query) == 0 {
+		return fmt.Sprintf("%q", path)
+	}
+	return fmt.Sprintf("%q + %s", path+"?", j.queryExpr(row))
+}
+
+// rowPath returns the path expression for row. The templated path is filled
+// with the recorded parameters, or with the values captured from an earlier
+// response. Rows recorded without a concrete path fall back to endpoint.
+func (j *JsonFile) rowPath(endpoint string, row *proxy.BodyRecords) string {
+	var subst map[string]string
+	if j.chain != nil {
+		subst = j.chain.subst[row]
 	}
-	sname, ok := j.models[endpoint]["name"]
+	segments, ok := fillPath(row.Path, row.PathParams)
 	if !ok {
-		return ""
+		segments, ok = splitSegments(row.RawPath), row.RawPath != ""
 	}
-	strct, ok := j.models[endpoint]["struct"]
 	if !ok {
-		return ""
+		return j.pathExpr(endpoint, *row)
 	}
-	funcName := getFuncName(endpoint)
-	structGen := structgen.StructGenerator{BaseDir: j.BaseDir, PkgPath: pkgPath}
-	var sb strings.Builder
-	fmt.Fprintf(&sb, `t.Run("Create %s", func(t *testing.T) {`, funcName)
-	if len(rows) == 1 {
-		rawJson := make(map[string]any)
-		if err := json.Unmarshal([]byte(rows[0].Body), &rawJson); err != nil {
-			return ""
+
+	parts := make([]string, 0)
+	literal := ""
+	for _, seg := range segments {
+		literal += "/"
+		if name, ok := subst[seg]; ok {
+			parts = append(parts, fmt.Sprintf("%q", literal), name)
+			literal = ""
+			continue
 		}
-		sm, ok := statusMap[rows[0].StatusCode]
-		if !ok {
-			sm = "http.StatusOK"
+		literal += seg
+	}
+	if literal == "" && len(parts) == 0 {
+		literal = "/"
+	}
+	if len(row.Query) > 0 {
+		literal += "?"
+	}
+	if literal != "" {
+		parts = append(parts, fmt.Sprintf("%q", literal))
+	}
+	expr := strings.Join(parts, " + ")
+	if len(row.Query) > 0 {
+		expr += " + " + j.queryExpr(*row)
+	}
+	return expr
+}
+
+// fillPath replaces the :name segments of a templated path with the recorded
+// parameter values, in order. It fails when they don't line up.
+func fillPath(path string, params []proxy.PathParam) ([]string, bool) {
+	if len(params) == 0 {
+		return nil, false
+	}
+	segments := splitSegments(path)
+	next := 0
+	for i, seg := range segments {
+		if !strings.HasPrefix(seg, ":") {
+			continue
 		}
-		strctStr, err := structGen.MapField(strct, rawJson)
-		if err != nil {
-			slog.Error("error parsing struct during codegeneration", "struct", strct, "pkg", structGen.PkgPath, "err", err)
-			sb.Reset()
-			return ""
+		if next == len(params) || params[next].Name != seg[1:] {
+			return nil, false
 		}
-		fmt.Fprintf(&sb, "payload := %s", sname)
-		sb.WriteString(strctStr)
-		sb.WriteString("\n\n")
-		fmt.Fprintf(&sb, `resp := makeReq(t, app, http.MethodPost, "%s", payload)`, endpoint)
-		sb.WriteString("\n")
-		fmt.Fprintf(&sb, `require.Equal(t, %s, resp.StatusCode)`, sm)
-		sb.WriteString("\n})")
-	} else {
-		fmt.Fprintf(&sb, "payloads := []struct{\nname string\npayload %s\nexpectedStatus int\n}{", sname)
-		for i := range rows {
-			rawJson := make(map[string]any)
-			sm, ok := statusMap[rows[i].StatusCode]
-			if !ok {
-				sm = "http.StatusOK"
-			}
-			fmt.Fprintf(&sb, `{
-				name: "%s %d",
-				expectedStatus: %s,
-				payload: %s `, funcName, i, sm, sname)
-			if err := json.Unmarshal([]byte(rows[i].Body), &rawJson); err != nil {
-				continue
-			}
-			strctStr, err := structGen.MapField(strct, rawJson)
-			if err != nil {
-				slog.Error("error parsing struct during codegeneration", "struct", strct, "pkg", structGen.PkgPath, "err", err)
-				sb.Reset()
-				return ""
-			}
-			sb.WriteString(strctStr)
-			sb.WriteString(",},\n")
+		segments[i] = params[next].Value
+		next++
+	}
+	return segments, next == len(params)
+}
+
+func splitSegments(path string) []string {
+	segments := make([]string, 0)
+	for seg := range strings.SplitSeq(path, "/") {
+		if seg != "" {
+			segments = append(segments, seg)
 		}
-		sb.WriteString("}\n")
-		sb.WriteString("for _, pl := range payloads {\n")
-		sb.WriteString("t.Run(pl.name, func(t *testing.T) {\n")
-		fmt.Fprintf(&sb, `resp := makeReq(t, app, http.MethodPost, "%s", pl.payload)`, endpoint)
-		sb.WriteString("\n")
-		sb.WriteString("require.Equal(t, http.StatusOK, resp.StatusCode)\n")
-		sb.WriteString("})\n")
-		sb.WriteString("}\n")
-		sb.WriteString("})")
 	}
+	return segments
+}
+
+func (j *JsonFile) queryExpr(row proxy.BodyRecords) string {
+	keys := slices.Sorted(maps.Keys(row.Query))
+	var sb strings.Builder
+	sb.WriteString("url.Values{")
+	for _, k := range keys {
+		fmt.Fprintf(&sb, "%q: {", k)
+		for _, v := range row.Query[k] {
+			fmt.Fprintf(&sb, "%s,", j.stringExpr(v))
+		}
+		sb.WriteString("},")
+	}
+	sb.WriteString("}.Encode()")
 	return sb.String()
 }
 
-func (j *JsonFile) genGetRun(endpoint string, rows []proxy.BodyRecords) string {
-	if len(rows) == 0 {
+// headerExpr returns an http.Header literal with the recorded request headers
+// worth sending again, or an empty string when there are none.
+func (j *JsonFile) headerExpr(header http.Header) string {
+	keys := make([]string, 0, len(header))
+	for k := range header {
+		if skipHeaders[http.CanonicalHeaderKey(k)] || strings.HasPrefix(http.CanonicalHeaderKey(k), "Sec-") {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	if len(keys) == 0 {
 		return ""
 	}
-	funcName := getFuncName(endpoint)
+	slices.Sort(keys)
 	var sb strings.Builder
-	fmt.Fprintf(&sb, `t.Run("Get %s", func(t *testing.T) {`, funcName)
-	if len(rows) == 1 {
-		sm, ok := statusMap[rows[0].StatusCode]
-		if !ok {
-			sm = "http.StatusOK"
-		}
-		fmt.Fprintf(&sb, `resp := makeReq(t, app, http.MethodGet, "%s", nil)`, endpoint)
-		sb.WriteString("\n")
-		fmt.Fprintf(&sb, `require.Equal(t, %s, resp.StatusCode)`, sm)
-		sb.WriteString("\n})")
-	} else {
-		sb.WriteString("testCases := []struct{\nname string\npath string\nexpectedStatus int\n}{\n")
-		for i := range rows {
-			sm, ok := statusMap[rows[i].StatusCode]
-			if !ok {
-				sm = "http.StatusOK"
+	sb.WriteString("http.Header{")
+	for _, k := range keys {
+		fmt.Fprintf(&sb, "%q: {", http.CanonicalHeaderKey(k))
+		for _, v := range header[k] {
+			fmt.Fprintf(&sb, "%s,", j.stringExpr(v))
+		}
+		sb.WriteString("},")
+	}
+	sb.WriteString("}")
+	return sb.String()
+}
+
+// stringExpr quotes a recorded value. Values scrubbed by the recorder's
+// redaction rules are read from the environment instead.
+func (j *JsonFile) stringExpr(v string) string {
+	if env, ok := proxy.ParsePlaceholder(v); ok {
+		return fmt.Sprintf("os.Getenv(%q)", env)
+	}
+	return fmt.Sprintf("%q", v)
+}
+
+func headerChecks(header http.Header) []headerCheck {
+	checks := make([]headerCheck, 0)
+	for _, name := range assertedHeaders {
+		if v := header.Get(name); v != "" {
+			if presentHeaders[name] {
+				v = ""
 			}
-			fmt.Fprintf(&sb, `{name: "%s %d", path: "%s", expectedStatus: %s},`, funcName, i, endpoint, sm)
-			sb.WriteString("\n")
+			checks = append(checks, headerCheck{name: name, value: v})
 		}
-		sb.WriteString("}\n")
-		sb.WriteString("for _, tc := range testCases {\n")
-		sb.WriteString("t.Run(tc.name, func(t *testing.T) {\n")
-		sb.WriteString("resp := makeReq(t, app, http.MethodGet, tc.path, nil)\n")
-		sb.WriteString("require.Equal(t, tc.expectedStatus, resp.StatusCode)\n")
-		sb.WriteString("})\n")
-		sb.WriteString("}\n")
-		sb.WriteString("})")
+	}
+	return checks
+}
+
+func (j *JsonFile) newTestCase(name, endpoint string, row *proxy.BodyRecords) testCase {
+	j.addOrigin(name, endpoint, row)
+	return testCase{
+		name:        name,
+		path:        j.rowPath(endpoint, row),
+		headers:     j.headerExpr(row.Header),
+		status:      statusExpr(row.StatusCode),
+		respHeaders: headerChecks(row.ResponseHeader),
+		check:       j.responseCheck(name, endpoint, row),
+	}
+}
+
+// responseCheck compares the response of row with the recorded JSON body,
+// ignoring volatile fields, and decodes it into the struct annotated for its
+// method and status so a response that no longer fits the struct fails the
+// test. Values captured for later requests are read from the same body. In
+// Golden mode the recorded body is compared from the golden file of the case
+// named name.
+func (j *JsonFile) responseCheck(name, endpoint string, row *proxy.BodyRecords) string {
+	if j.matcher == nil {
+		j.matcher = &bodyMatcher{}
+	}
+	expected, ok := j.matcher.expected(row.ResponseBody)
+	if !ok || row.Method == "HEAD" {
+		return j.captureCode(row)
+	}
+	model := j.routes[endpoint].ResponseModel(row.Method, templatePath(row), row.StatusCode)
+	typ, assigns := j.captureParts(row)
+	var sb strings.Builder
+	switch {
+	case model != nil && typ != "":
+		fmt.Fprintf(&sb, "_, body := decodeResp[%s](t, resp)\nvar created %s\nrequire.NoError(t, json.Unmarshal(body, &created))\n%s", model.Name, typ, assigns)
+	case model != nil:
+		fmt.Fprintf(&sb, "_, body := decodeResp[%s](t, resp)\n", model.Name)
+	case typ != "":
+		fmt.Fprintf(&sb, "created, body := decodeResp[%s](t, resp)\n%s", typ, assigns)
+	default:
+		sb.WriteString("_, body := decodeResp[any](t, resp)\n")
+	}
+	if j.Golden {
+		fmt.Fprintf(&sb, "requireGolden(t, body, %q)\n", j.addGolden(row.Method, name, expected))
+	} else {
+		fmt.Fprintf(&sb, "requireJSON(t, body, %s)\n", bodyLiteral(expected))
 	}
 	return sb.String()
 }
 
-func (j *JsonFile) genDelRun(endpoint string, rows []proxy.BodyRecords) string {
-	if len(rows) == 0 {
+// genRun writes one subtest per method. A single recorded row is inlined,
+// several rows become a table driven test.
+func (j *JsonFile) genRun(label, method, endpoint, payloadType string, cases []testCase) string {
+	if len(cases) == 0 {
 		return ""
 	}
 	funcName := getFuncName(endpoint)
+	payloadArg := "nil"
 	var sb strings.Builder
-	fmt.Fprintf(&sb, `t.Run("Delete %s", func(t *testing.T) {`, funcName)
-	if len(rows) == 1 {
-		sm, ok := statusMap[rows[0].StatusCode]
-		if !ok {
-			sm = "http.StatusOK"
-		}
-		fmt.Fprintf(&sb, `resp := makeReq(t, app, http.MethodDelete, "%s", nil)`, endpoint)
-		sb.WriteString("\n")
-		fmt.Fprintf(&sb, `require.Equal(t, %s, resp.StatusCode)`, sm)
-		sb.WriteString("\n})")
-	} else {
-		sb.WriteString("testCases := []struct{\nname string\npath string\nexpectedStatus int\n}{\n")
-		for i := range rows {
-			sm, ok := statusMap[rows[i].StatusCode]
-			if !ok {
-				sm = "http.StatusOK"
+	fmt.Fprintf(&sb, `t.Run("%s %s", func(t *testing.T) {`, label, funcName)
+	sb.WriteString("\n")
+	if len(cases) == 1 {
+		j.aliasOrigin(label+" "+funcName, cases[0].name)
+		writeCall(&sb, method, cases[0])
+		sb.WriteString("})")
+		return sb.String()
+	}
+
+	hasHeaders := slices.ContainsFunc(cases, func(tc testCase) bool { return tc.headers != "" })
+	hasRespHeaders := slices.ContainsFunc(cases, func(tc testCase) bool { return len(tc.respHeaders) > 0 })
+	hasCheck := slices.ContainsFunc(cases, func(tc testCase) bool { return tc.check != "" })
+
+	sb.WriteString("testCases := []struct{\nname string\npath string\n")
+	if payloadType != "" {
+		fmt.Fprintf(&sb, "payload %s\n", payloadType)
+		payloadArg = "tc.payload"
+	}
+	if hasHeaders {
+		sb.WriteString("headers http.Header\n")
+		payloadArg += ", tc.headers"
+	}
+	sb.WriteString("expectedStatus int\n")
+	if hasRespHeaders {
+		sb.WriteString("expectedHeaders map[string]string\n")
+	}
+	if hasCheck {
+		sb.WriteString("checkResponse func(t *testing.T, resp *http.Response)\n")
+	}
+	sb.WriteString("}{\n")
+	for _, tc := range cases {
+		fmt.Fprintf(&sb, `{name: "%s", path: %s, `, tc.name, tc.path)
+		if payloadType != "" {
+			fmt.Fprintf(&sb, "payload: %s, ", tc.payload)
+		}
+		if tc.headers != "" {
+			fmt.Fprintf(&sb, "headers: %s, ", tc.headers)
+		}
+		fmt.Fprintf(&sb, "expectedStatus: %s", tc.status)
+		if len(tc.respHeaders) > 0 {
+			sb.WriteString(", expectedHeaders: map[string]string{")
+			for _, h := range tc.respHeaders {
+				fmt.Fprintf(&sb, "%q: %q,", h.name, h.value)
 			}
-			fmt.Fprintf(&sb, `{name: "%s %d", path: "%s", expectedStatus: %s},`, funcName, i, endpoint, sm)
-			sb.WriteString("\n")
+			sb.WriteString("}")
 		}
+		if tc.check != "" {
+			fmt.Fprintf(&sb, ", checkResponse: func(t *testing.T, resp *http.Response) {\n%s}", tc.check)
+		}
+		sb.WriteString("},\n")
+	}
+	sb.WriteString("}\n")
+	sb.WriteString("for _, tc := range testCases {\n")
+	sb.WriteString("t.Run(tc.name, func(t *testing.T) {\n")
+	fmt.Fprintf(&sb, "resp := makeReq(t, app, %s, tc.path, %s)\n", method, payloadArg)
+	sb.WriteString("require.Equal(t, tc.expectedStatus, resp.StatusCode)\n")
+	if hasRespHeaders {
+		sb.WriteString("for name, value := range tc.expectedHeaders {\n")
+		sb.WriteString("if value == \"\" {\nrequire.NotEmpty(t, resp.Header.Get(name), name)\ncontinue\n}\n")
+		sb.WriteString("require.Equal(t, value, resp.Header.Get(name))\n")
 		sb.WriteString("}\n")
-		sb.WriteString("for _, tc := range testCases {\n")
-		sb.WriteString("t.Run(tc.name, func(t *testing.T) {\n")
-		sb.WriteString("resp := makeReq(t, app, http.MethodDelete, tc.path, nil)\n")
-		sb.WriteString("require.Equal(t, tc.expectedStatus, resp.StatusCode)\n")
-		sb.WriteString("})\n")
-		sb.WriteString("}\n")
-		sb.WriteString("})")
 	}
+	if hasCheck {
+		sb.WriteString("if tc.checkResponse != nil {\ntc.checkResponse(t, resp)\n}\n")
+	}
+	sb.WriteString("})\n")
+	sb.WriteString("}\n")
+	sb.WriteString("})")
 	return sb.String()
 }
 
-func (j *JsonFile) genPutRun(endpoint string, rows []proxy.BodyRecords) string {
-	if len(rows) == 0 {
-		return ""
+// writeCall writes the request and assertions of a single inlined case.
+func writeCall(sb *strings.Builder, method string, tc testCase) {
+	payloadArg := "nil"
+	if tc.payload != "" {
+		fmt.Fprintf(sb, "payload := %s\n\n", tc.payload)
+		payloadArg = "payload"
 	}
-	pkgPath, ok := j.models[endpoint]["folder"]
-	if !ok {
-		return ""
+	if tc.headers != "" {
+		payloadArg += ", " + tc.headers
 	}
-	sname, ok := j.models[endpoint]["name"]
-	if !ok {
-		return ""
+	fmt.Fprintf(sb, "resp := makeReq(t, app, %s, %s, %s)\n", method, tc.path, payloadArg)
+	fmt.Fprintf(sb, "require.Equal(t, %s, resp.StatusCode)\n", tc.status)
+	for _, h := range tc.respHeaders {
+		if h.value == "" {
+			fmt.Fprintf(sb, "require.NotEmpty(t, resp.Header.Get(%q))\n", h.name)
+			continue
+		}
+		fmt.Fprintf(sb, "require.Equal(t, %q, resp.Header.Get(%q))\n", h.value, h.name)
 	}
-	strct, ok := j.models[endpoint]["struct"]
-	if !ok {
-		return ""
+	sb.WriteString(tc.check)
+}
+
+func (j *JsonFile) noBodyCases(endpoint string, rows []proxy.BodyRecords) []testCase {
+	funcName := getFuncName(endpoint)
+	cases := make([]testCase, 0, len(rows))
+	for i := range rows {
+		cases = append(cases, j.newTestCase(fmt.Sprintf("%s %d", funcName, i), endpoint, &rows[i]))
+	}
+	return cases
+}
+
+// payloadCases maps every recorded request body onto the struct annotated for
+// the endpoint, or the struct inferred from the bodies when there is none. It
+// returns an empty type when no body is a JSON object.
+func (j *JsonFile) payloadCases(endpoint string, rows []proxy.BodyRecords) (string, []testCase) {
+	if len(rows) == 0 {
+		return "", nil
 	}
 	funcName := getFuncName(endpoint)
-	structGen := structgen.StructGenerator{BaseDir: j.BaseDir, PkgPath: pkgPath}
-	var sb strings.Builder
-	fmt.Fprintf(&sb, `t.Run("Update %s", func(t *testing.T) {`, funcName)
-	if len(rows) == 1 {
-		rawJson := make(map[string]any)
-		if err := json.Unmarshal([]byte(rows[0].Body), &rawJson); err != nil {
-			return ""
+	j.inferBodies(endpoint, rows[0].Method, rows)
+	var sname string
+	cases := make([]testCase, 0, len(rows))
+	for i := range rows {
+		name, payload, err := j.payload(endpoint, &rows[i])
+		if errors.Is(err, errNoModel) {
+			return "", nil
 		}
-		sm, ok := statusMap[rows[0].StatusCode]
-		if !ok {
-			sm = "http.StatusOK"
+		if errors.Is(err, errNotJSON) {
+			slog.Warn("skipping row with non JSON body", "endpoint", endpoint, "method", rows[i].Method, "row", i)
+			continue
 		}
-		strctStr, err := structGen.MapField(strct, rawJson)
 		if err != nil {
-			slog.Error("error parsing struct during codegeneration", "struct", strct, "pkg", structGen.PkgPath, "err", err)
-			sb.Reset()
-			return ""
+			slog.Error("error parsing struct during codegeneration", "endpoint", endpoint, "err", err)
+			return "", nil
 		}
-		fmt.Fprintf(&sb, "payload := %s", sname)
-		sb.WriteString(strctStr)
-		sb.WriteString("\n\n")
-		fmt.Fprintf(&sb, `resp := makeReq(t, app, http.MethodPut, "%s", payload)`, endpoint)
-		sb.WriteString("\n")
-		fmt.Fprintf(&sb, `require.Equal(t, %s, resp.StatusCode)`, sm)
-		sb.WriteString("\n})")
-	} else {
+		sname = name
+		tc := j.newTestCase(fmt.Sprintf("%s %d", funcName, i), endpoint, &rows[i])
+		tc.payload = payload
+		cases = append(cases, tc)
+	}
+	return sname, cases
+}
 
-		fmt.Fprintf(&sb, "payloads := []struct{\nname string\npayload %s\nexpectedStatus int\n}{\n", sname)
-		for i := range rows {
-			rawJson := make(map[string]any)
-			sm, ok := statusMap[rows[i].StatusCode]
-			if !ok {
-				sm = "http.StatusOK"
-			}
-			fmt.Fprintf(&sb, `{name: "%s %d",expectedStatus: %s, payload: %s `, funcName, i, sm, sname)
-			if err := json.Unmarshal([]byte(rows[i].Body), &rawJson); err != nil {
-				continue
-			}
-			strctStr, err := structGen.MapField(strct, rawJson)
-			if err != nil {
-				slog.Error("error parsing struct during codegeneration", "struct", strct, "pkg", structGen.PkgPath, "err", err)
-				sb.Reset()
-				return ""
-			}
-			sb.WriteString(strctStr)
-			sb.WriteString(",},\n")
+var (
+	errNoModel = errors.New("no annotated struct")
+	errNotJSON = errors.New("body is not a JSON object")
+)
+
+// payload returns the type and a literal holding the recorded request body.
+// Bodies are mapped onto the request struct annotated for the method, or the
+// struct of the endpoint. PATCH bodies without their own struct become a
+// partial struct with only the recorded fields. Endpoints without a struct
+// use the one inferred by inferBodies. ID fields taken from an earlier
+// response are filled from the variable capturing them.
+func (j *JsonFile) payload(endpoint string, row *proxy.BodyRecords) (string, string, error) {
+	model, partial := j.routes[endpoint].RequestModel(row.Method, templatePath(row))
+	if model == nil || model.Folder == "" || model.Struct == "" {
+		return j.inferredPayload(endpoint, row)
+	}
+	rawJson := make(map[string]any)
+	if err := json.Unmarshal([]byte(row.Body), &rawJson); err != nil {
+		return "", "", errNotJSON
+	}
+	structGen := structgen.StructGenerator{BaseDir: j.BaseDir, PkgPath: model.Folder, Vars: j.chain.bodyVars(row)}
+	if partial {
+		// every row gets its own anonymous type, table columns hold them as any
+		lit, err := structGen.MapPartial(model.Struct, rawJson)
+		if err != nil {
+			return "", "", fmt.Errorf("mapping partial %s from %s :%v", model.Struct, model.Folder, err)
 		}
-		sb.WriteString("}\n")
-		sb.WriteString("for _, pl := range payloads {\n")
-		sb.WriteString("t.Run(pl.name, func(t *testing.T) {\n")
-		fmt.Fprintf(&sb, `resp := makeReq(t, app, http.MethodPut, "%s", pl.payload)`, endpoint)
-		sb.WriteString("\n")
-		sb.WriteString("require.Equal(t, pl.expectedStatus, resp.StatusCode)\n")
-		sb.WriteString("})\n")
-		sb.WriteString("}\n")
-		sb.WriteString("})")
+		return "any", lit, nil
 	}
-	return sb.String()
+	strctStr, err := structGen.MapField(model.Struct, rawJson)
+	if err != nil {
+		return "", "", fmt.Errorf("mapping %s from %s :%v", model.Struct, model.Folder, err)
+	}
+	return model.Name, model.Name + strctStr, nil
+}
+
+func (j *JsonFile) genPostRun(endpoint string, rows []proxy.BodyRecords) string {
+	payloadType, cases := j.payloadCases(endpoint, rows)
+	return j.genRun("Create", "http.MethodPost", endpoint, payloadType, cases)
+}
+
+func (j *JsonFile) genPutRun(endpoint string, rows []proxy.BodyRecords) string {
+	payloadType, cases := j.payloadCases(endpoint, rows)
+	return j.genRun("Update", "http.MethodPut", endpoint, payloadType, cases)
+}
+
+func (j *JsonFile) genPatchRun(endpoint string, rows []proxy.BodyRecords) string {
+	payloadType, cases := j.payloadCases(endpoint, rows)
+	return j.genRun("Patch", "http.MethodPatch", endpoint, payloadType, cases)
+}
+
+func (j *JsonFile) genGetRun(endpoint string, rows []proxy.BodyRecords) string {
+	return j.genRun("Get", "http.MethodGet", endpoint, "", j.noBodyCases(endpoint, rows))
+}
+
+func (j *JsonFile) genHeadRun(endpoint string, rows []proxy.BodyRecords) string {
+	return j.genRun("Head", "http.MethodHead", endpoint, "", j.noBodyCases(endpoint, rows))
+}
+
+func (j *JsonFile) genOptionsRun(endpoint string, rows []proxy.BodyRecords) string {
+	return j.genRun("Options", "http.MethodOptions", endpoint, "", j.noBodyCases(endpoint, rows))
+}
+
+func (j *JsonFile) genDelRun(endpoint string, rows []proxy.BodyRecords) string {
+	return j.genRun("Delete", "http.MethodDelete", endpoint, "", j.noBodyCases(endpoint, rows))
+}
+
+// methodRuns lists the methods covered by endpoint tests, in the order their
+// subtests run. Creates come first so later requests can use their IDs and
+// deletes come last.
+var methodRuns = []struct {
+	method string
+	gen    func(j *JsonFile, endpoint string, rows []proxy.BodyRecords) string
+}{
+	{"POST", (*JsonFile).genPostRun},
+	{"PUT", (*JsonFile).genPutRun},
+	{"PATCH", (*JsonFile).genPatchRun},
+	{"GET", (*JsonFile).genGetRun},
+	{"HEAD", (*JsonFile).genHeadRun},
+	{"OPTIONS", (*JsonFile).genOptionsRun},
+	{"DELETE", (*JsonFile).genDelRun},
 }
 
 func (j *JsonFile) genTestFunction(ep string, rcrd proxy.Recording) string {
+	groups := make([][]proxy.BodyRecords, len(methodRuns))
+	rows := make([]*proxy.BodyRecords, 0, len(rcrd.Body))
+	endpoints := make([]string, 0, len(rcrd.Body))
+	j.rows = make(map[*proxy.BodyRecords]int, len(rcrd.Body))
+	for i, run := range methodRuns {
+		groups[i] = filterByMethod(rcrd.Body, run.method)
+		k := 0
+		for n := range rcrd.Body {
+			if rcrd.Body[n].Method == run.method {
+				rows = append(rows, &groups[i][k])
+				endpoints = append(endpoints, ep)
+				j.rows[&groups[i][k]] = n
+				k++
+			}
+		}
+	}
+	j.chain = planChain(rows, endpoints)
+	j.testName = getFuncName(ep)
+	defer func() { j.chain, j.testName, j.rows = nil, "", nil }()
+
 	var sb strings.Builder
 	fmt.Fprintf(&sb, "func test%s(t *testing.T){\n", getFuncName(ep))
-	sb.WriteString("\n\napp := setup()\n\n")
-	sb.WriteString(j.genPostRun(ep, filterByMethod(rcrd.Body, "POST")))
-	sb.WriteString("\n\n")
-	sb.WriteString(j.genPutRun(ep, filterByMethod(rcrd.Body, "PUT")))
-	sb.WriteString("\n\n")
-	sb.WriteString(j.genGetRun(ep, filterByMethod(rcrd.Body, "GET")))
-	sb.WriteString("\n\n")
-	sb.WriteString(j.genDelRun(ep, filterByMethod(rcrd.Body, "DELETE")))
-	sb.WriteString("\n\n}\n\n")
+	sb.WriteString("app := setup()\n\n")
+	sb.WriteString(j.chain.declarations())
+	for i, run := range methodRuns {
+		if len(groups[i]) == 0 {
+			continue
+		}
+		sb.WriteString(run.gen(j, ep, groups[i]))
+		sb.WriteString("\n\n")
+	}
+	sb.WriteString("}\n\n")
 
 	return sb.String()
 }
@@ -377,24 +713,63 @@ func (j *JsonFile) GenTest() error {
 	if err != nil {
 		return fmt.Errorf("error detecting files on %s, :%v", testFileDir, err)
 	}
+	if j.matcher, err = newBodyMatcher(j.Assert); err != nil {
+		return err
+	}
 	fname := j.getFileName()
-	var sb strings.Builder
-	sb.WriteString(`package gentests
-	import (
-			"net/http"
-			"testing"
-			"github.com/stretchr/testify/require"
-			)
-
-	`)
+	if j.Scenario {
+		fname = scenarioFileName
+	}
+	j.inferred, j.typeNames = nil, nil
+	j.goldens, j.origins = nil, nil
 
+	var sb strings.Builder
+	sb.WriteString("package gentests\n\n")
+	if j.Scenario {
+		sb.WriteString(j.genScenarios())
+	}
 	for key, item := range j.recordings {
 		sb.WriteString(j.genTestFunction(key, item))
 	}
-	err = os.WriteFile(filepath.Join("./gentest", fname), []byte(sb.String()), 0o644)
+	imports := j.importPaths()
+	src, err := formatFile([]byte(sb.String()), imports)
+	if err != nil {
+		return fmt.Errorf("error formatting test file %s :%v", fname, err)
+	}
+	path := filepath.Join("./gentest", fname)
+	if old, err := os.ReadFile(path); err == nil {
+		if src, err = mergeKept(old, src); err != nil {
+			return fmt.Errorf("error keeping user code of %s :%v", fname, err)
+		}
+		if src, err = formatFile(src, imports); err != nil {
+			return fmt.Errorf("error formatting test file %s :%v", fname, err)
+		}
+	}
+	types, err := j.typesSource()
+	if err != nil {
+		return err
+	}
+	if j.Check {
+		files := map[string][]byte{fname: src}
+		if types != nil {
+			files[typesFileName] = types
+		}
+		if err := j.checkFiles(files); err != nil {
+			return err
+		}
+	}
+	err = os.WriteFile(path, src, 0o644)
 	if err != nil {
 		return fmt.Errorf("error writing test file %s :%v", fname, err)
 	}
+	if types != nil {
+		if err := os.WriteFile(filepath.Join(testFileDir, typesFileName), types, 0o644); err != nil {
+			return fmt.Errorf("error writing types file :%v", err)
+		}
+	}
+	if err := j.writeGoldens(); err != nil {
+		return fmt.Errorf("error writing golden files :%v", err)
+	}
 
 	return nil
 }
@@ -0,0 +1,525 @@
+package generator
+
+import (
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/types"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// framework describes how a router package registers routes. Methods are
+// matched on the package of the called method, so routers embedded in other
+// types or held in interfaces are recognised too.
+type framework struct {
+	name string
+	pkg  string
+	// verbs are methods registering a route for one HTTP method with
+	// (path, handlers...).
+	verbs map[string]string
+	// handle are methods taking (method, path, handlers...).
+	handle map[string]bool
+	// group are methods returning a router under (prefix, ...).
+	group map[string]bool
+	// route are methods calling a func(router) with routes under (prefix, fn).
+	route map[string]bool
+	// mount are methods attaching another router under (prefix, router).
+	mount map[string]bool
+	// with are methods returning the router itself with extra middlewares.
+	with map[string]bool
+}
+
+var (
+	titleVerbs = map[string]string{
+		"Get": "GET", "Head": "HEAD", "Post": "POST", "Put": "PUT",
+		"Patch": "PATCH", "Delete": "DELETE", "Options": "OPTIONS",
+	}
+	upperVerbs = map[string]string{
+		"GET": "GET", "HEAD": "HEAD", "POST": "POST", "PUT": "PUT",
+		"PATCH": "PATCH", "DELETE": "DELETE", "OPTIONS": "OPTIONS",
+	}
+)
+
+var frameworks = []framework{
+	{name: "net/http", pkg: "net/http"},
+	{name: "chi", pkg: "github.com/go-chi/chi", verbs: titleVerbs,
+		handle: map[string]bool{"Method": true, "MethodFunc": true},
+		route:  map[string]bool{"Route": true, "Group": true},
+		mount:  map[string]bool{"Mount": true},
+		with:   map[string]bool{"With": true}},
+	{name: "gin", pkg: "github.com/gin-gonic/gin", verbs: upperVerbs,
+		handle: map[string]bool{"Handle": true},
+		group:  map[string]bool{"Group": true}},
+	{name: "echo", pkg: "github.com/labstack/echo", verbs: upperVerbs,
+		handle: map[string]bool{"Add": true},
+		group:  map[string]bool{"Group": true}},
+	{name: "fiber", pkg: "github.com/gofiber/fiber", verbs: titleVerbs,
+		group: map[string]bool{"Group": true},
+		route: map[string]bool{"Route": true},
+		// fiber v3 mounts sub apps with Use
+		mount: map[string]bool{"Mount": true, "Use": true}},
+}
+
+// decodeCalls are the calls handlers read their request body with, mapped to
+// the argument holding the destination.
+var decodeCalls = map[string]int{
+	"Decode":         0, // json.NewDecoder(r.Body).Decode(&req)
+	"Unmarshal":      1, // json.Unmarshal(body, &req)
+	"Bind":           0, // echo and gin
+	"BindJSON":       0,
+	"ShouldBind":     0,
+	"ShouldBindJSON": 0,
+	"BodyParser":     0, // fiber v2
+	"Body":           0, // fiber v3 c.Bind().Body(&req)
+}
+
+// discoveredRoute is a route registration found in the router setup code.
+type discoveredRoute struct {
+	method string
+	path   string
+	body   *Model
+}
+
+type discoverer struct {
+	inputDir string
+	pkgs     []*packages.Package
+	// dirs maps the import path of every loaded package to its folder,
+	// relative to inputDir.
+	dirs     map[string]string
+	decls    map[*types.Func]*ast.FuncDecl
+	prefixes map[types.Object]string
+}
+
+// DiscoverRoutes finds the routes registered in the packages under InputDir
+// and the struct each handler decodes its request body into. It understands
+// http.ServeMux, chi, gin, echo and fiber.
+func (s *Scanner) DiscoverRoutes() (map[string]*Route, error) {
+	// dependencies are type checked from source, export data written by a
+	// newer toolchain than x/tools knows can't be read
+	cfg := &packages.Config{
+		Mode: packages.NeedName | packages.NeedFiles | packages.NeedSyntax |
+			packages.NeedImports | packages.NeedDeps | packages.NeedTypes | packages.NeedTypesInfo,
+		Dir: s.InputDir,
+	}
+	pkgs, err := packages.Load(cfg, "./...")
+	if err != nil {
+		return nil, fmt.Errorf("loading packages in %s: %w", s.InputDir, err)
+	}
+	d := &discoverer{
+		inputDir: s.InputDir,
+		pkgs:     pkgs,
+		dirs:     make(map[string]string),
+		decls:    make(map[*types.Func]*ast.FuncDecl),
+		prefixes: make(map[types.Object]string),
+	}
+	for _, pkg := range pkgs {
+		for _, e := range pkg.Errors {
+			slog.Warn("package has errors, routes may be missed", "package", pkg.PkgPath, "err", e)
+		}
+		if len(pkg.GoFiles) > 0 {
+			if rel, err := filepath.Rel(s.InputDir, filepath.Dir(pkg.GoFiles[0])); err == nil {
+				d.dirs[pkg.PkgPath] = filepath.ToSlash(rel)
+			}
+		}
+		for _, f := range pkg.Syntax {
+			for _, decl := range f.Decls {
+				fd, ok := decl.(*ast.FuncDecl)
+				if !ok || pkg.TypesInfo == nil {
+					continue
+				}
+				if fn, ok := pkg.TypesInfo.Defs[fd.Name].(*types.Func); ok {
+					d.decls[fn] = fd
+				}
+			}
+		}
+	}
+
+	// prefixes flow through assignments, closures and calls in any order,
+	// resolve them before reading the registrations
+	for range 10 {
+		if !d.resolvePrefixes() {
+			break
+		}
+	}
+	routes := make(map[string]*Route)
+	for _, r := range d.registrations() {
+		if r.body == nil {
+			continue
+		}
+		key := routeKey(r.path)
+		route, ok := routes[key]
+		if !ok {
+			route = &Route{Router: r.path}
+			routes[key] = route
+		}
+		if slices.ContainsFunc(route.Methods, func(m *MethodModels) bool {
+			return m.Method == r.method && m.Router == r.path
+		}) {
+			continue
+		}
+		route.Methods = append(route.Methods, &MethodModels{Router: r.path, Method: r.method, Request: r.body})
+	}
+	return routes, nil
+}
+
+// routerCall returns the framework and method name of a call to a router
+// method, or nil when call is something else.
+func routerCall(info *types.Info, call *ast.CallExpr) (*framework, string, ast.Expr) {
+	sel, ok := ast.Unparen(call.Fun).(*ast.SelectorExpr)
+	if !ok {
+		return nil, "", nil
+	}
+	fn, ok := info.Uses[sel.Sel].(*types.Func)
+	if !ok || fn.Pkg() == nil {
+		return nil, "", nil
+	}
+	for i := range frameworks {
+		fw := &frameworks[i]
+		if fn.Pkg().Path() == fw.pkg || strings.HasPrefix(fn.Pkg().Path(), fw.pkg+"/") {
+			return fw, sel.Sel.Name, sel.X
+		}
+	}
+	return nil, "", nil
+}
+
+// resolvePrefixes records the path prefix of every router variable and
+// parameter, and reports whether anything changed.
+func (d *discoverer) resolvePrefixes() bool {
+	changed := false
+	set := func(obj types.Object, prefix string) {
+		if obj == nil || prefix == "" {
+			return
+		}
+		if old, ok := d.prefixes[obj]; !ok || old != prefix {
+			d.prefixes[obj] = prefix
+			changed = true
+		}
+	}
+	for _, pkg := range d.pkgs {
+		info := pkg.TypesInfo
+		if info == nil {
+			continue
+		}
+		for _, f := range pkg.Syntax {
+			ast.Inspect(f, func(n ast.Node) bool {
+				switch n := n.(type) {
+				case *ast.AssignStmt:
+					if len(n.Lhs) == len(n.Rhs) {
+						for i, lhs := range n.Lhs {
+							if id, ok := lhs.(*ast.Ident); ok {
+								set(info.ObjectOf(id), d.prefixOf(info, n.Rhs[i]))
+							}
+						}
+					}
+				case *ast.ValueSpec:
+					if len(n.Names) == len(n.Values) {
+						for i, id := range n.Names {
+							set(info.ObjectOf(id), d.prefixOf(info, n.Values[i]))
+						}
+					}
+				case *ast.CallExpr:
+					fw, name, recv := routerCall(info, n)
+					if fw != nil && fw.route[name] {
+						prefix := d.prefixOf(info, recv)
+						for _, arg := range n.Args {
+							if p, ok := stringValue(info, arg); ok {
+								prefix += p
+								continue
+							}
+							lit, ok := arg.(*ast.FuncLit)
+							if !ok {
+								continue
+							}
+							for _, param := range lit.Type.Params.List {
+								for _, id := range param.Names {
+									set(info.ObjectOf(id), prefix)
+								}
+							}
+							break
+						}
+						return true
+					}
+					if fw != nil && fw.mount[name] && len(n.Args) == 2 {
+						if p, ok := stringValue(info, n.Args[0]); ok {
+							for _, obj := range d.routerObjects(info, n.Args[1]) {
+								set(obj, d.prefixOf(info, recv)+p)
+							}
+						}
+						return true
+					}
+					// routers passed to setup functions keep their prefix
+					fn := calledFunc(info, n)
+					decl := d.decls[fn]
+					if decl == nil {
+						return true
+					}
+					params := make([]*ast.Ident, 0)
+					for _, field := range decl.Type.Params.List {
+						params = append(params, field.Names...)
+					}
+					for i, arg := range n.Args {
+						if i < len(params) {
+							set(d.infoOf(fn).ObjectOf(params[i]), d.prefixOf(info, arg))
+						}
+					}
+				}
+				return true
+			})
+		}
+	}
+	return changed
+}
+
+// routerObjects returns the variables holding the router expr evaluates to:
+// the variable itself, or the ones returned by the setup function called.
+func (d *discoverer) routerObjects(info *types.Info, expr ast.Expr) []types.Object {
+	switch e := ast.Unparen(expr).(type) {
+	case *ast.Ident:
+		return []types.Object{info.ObjectOf(e)}
+	case *ast.CallExpr:
+		fn := calledFunc(info, e)
+		decl := d.decls[fn]
+		if decl == nil || decl.Body == nil {
+			return nil
+		}
+		objs := make([]types.Object, 0)
+		ast.Inspect(decl.Body, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.FuncLit:
+				return false
+			case *ast.ReturnStmt:
+				for _, res := range n.Results {
+					if id, ok := ast.Unparen(res).(*ast.Ident); ok {
+						objs = append(objs, d.infoOf(fn).ObjectOf(id))
+					}
+				}
+			}
+			return true
+		})
+		return objs
+	}
+	return nil
+}
+
+// prefixOf returns the path prefix routes registered on the router expr get.
+func (d *discoverer) prefixOf(info *types.Info, expr ast.Expr) string {
+	switch e := ast.Unparen(expr).(type) {
+	case *ast.Ident:
+		return d.prefixes[info.ObjectOf(e)]
+	case *ast.CallExpr:
+		fw, name, recv := routerCall(info, e)
+		if fw != nil && fw.with[name] {
+			return d.prefixOf(info, recv)
+		}
+		if fw == nil || !fw.group[name] || len(e.Args) == 0 {
+			return ""
+		}
+		p, _ := stringValue(info, e.Args[0])
+		return d.prefixOf(info, recv) + p
+	}
+	return ""
+}
+
+// registrations returns every route registered in the loaded packages.
+func (d *discoverer) registrations() []discoveredRoute {
+	routes := make([]discoveredRoute, 0)
+	for _, pkg := range d.pkgs {
+		info := pkg.TypesInfo
+		if info == nil {
+			continue
+		}
+		for _, f := range pkg.Syntax {
+			ast.Inspect(f, func(n ast.Node) bool {
+				call, ok := n.(*ast.CallExpr)
+				if !ok {
+					return true
+				}
+				if r, ok := d.registration(info, call); ok {
+					routes = append(routes, r)
+				}
+				return true
+			})
+		}
+	}
+	return routes
+}
+
+func (d *discoverer) registration(info *types.Info, call *ast.CallExpr) (discoveredRoute, bool) {
+	fw, name, recv := routerCall(info, call)
+	if fw == nil {
+		return discoveredRoute{}, false
+	}
+	var method, path string
+	var handlers []ast.Expr
+	switch {
+	case fw.name == "net/http" && (name == "HandleFunc" || name == "Handle") && len(call.Args) == 2:
+		pattern, ok := stringValue(info, call.Args[0])
+		if !ok {
+			return discoveredRoute{}, false
+		}
+		method, path = splitServeMuxPattern(pattern)
+		handlers = call.Args[1:]
+	case fw.verbs[name] != "" && len(call.Args) >= 2:
+		p, ok := stringValue(info, call.Args[0])
+		if !ok {
+			return discoveredRoute{}, false
+		}
+		method, path = fw.verbs[name], p
+		handlers = call.Args[1:]
+	case fw.handle[name] && len(call.Args) >= 3:
+		m, ok := stringValue(info, call.Args[0])
+		p, ok2 := stringValue(info, call.Args[1])
+		if !ok || !ok2 {
+			return discoveredRoute{}, false
+		}
+		method, path = strings.ToUpper(m), p
+		handlers = call.Args[2:]
+	default:
+		return discoveredRoute{}, false
+	}
+	if fw.name != "net/http" {
+		path = d.prefixOf(info, recv) + path
+	}
+	r := discoveredRoute{method: method, path: path}
+	// middlewares come first, the last handler reads the body
+	for i := len(handlers) - 1; i >= 0 && r.body == nil; i-- {
+		if body := d.handlerBody(info, handlers[i]); body != nil {
+			r.body = d.bodyModel(body)
+		}
+	}
+	return r, true
+}
+
+// splitServeMuxPattern splits a Go 1.22 pattern such as "POST /users/{id}" or
+// "GET example.com/users/{$}" into its method and path.
+func splitServeMuxPattern(pattern string) (string, string) {
+	method, path, ok := strings.Cut(strings.TrimSpace(pattern), " ")
+	if !ok {
+		method, path = "", method
+	}
+	path = strings.TrimSpace(path)
+	if i := strings.Index(path, "/"); i > 0 {
+		path = path[i:]
+	}
+	path = strings.TrimSuffix(path, "{$}")
+	return strings.ToUpper(method), path
+}
+
+// handlerBody returns the function a handler expression runs. Conversions
+// such as http.HandlerFunc(h) and middleware wrapping a handler are looked
+// through.
+func (d *discoverer) handlerBody(info *types.Info, expr ast.Expr) *handlerFunc {
+	switch e := ast.Unparen(expr).(type) {
+	case *ast.FuncLit:
+		return &handlerFunc{info: info, body: e.Body}
+	case *ast.Ident, *ast.SelectorExpr:
+		fn := calledFunc(info, &ast.CallExpr{Fun: e})
+		if decl := d.decls[fn]; decl != nil && decl.Body != nil {
+			return &handlerFunc{info: d.infoOf(fn), body: decl.Body}
+		}
+	case *ast.CallExpr:
+		for i := len(e.Args) - 1; i >= 0; i-- {
+			if h := d.handlerBody(info, e.Args[i]); h != nil {
+				return h
+			}
+		}
+	}
+	return nil
+}
+
+type handlerFunc struct {
+	info *types.Info
+	body *ast.BlockStmt
+}
+
+// bodyModel returns the struct the handler decodes the request body into.
+func (d *discoverer) bodyModel(h *handlerFunc) *Model {
+	var model *Model
+	ast.Inspect(h.body, func(n ast.Node) bool {
+		if model != nil {
+			return false
+		}
+		call, ok := n.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		sel, ok := ast.Unparen(call.Fun).(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		idx, ok := decodeCalls[sel.Sel.Name]
+		if !ok || idx >= len(call.Args) {
+			return true
+		}
+		model = d.modelOf(h.info.TypeOf(call.Args[idx]))
+		return model == nil
+	})
+	return model
+}
+
+// modelOf returns the model of a pointer to a named struct declared in one of
+// the loaded packages.
+func (d *discoverer) modelOf(t types.Type) *Model {
+	ptr, ok := t.(*types.Pointer)
+	if !ok {
+		return nil
+	}
+	named, ok := types.Unalias(ptr.Elem()).(*types.Named)
+	if !ok || named.Obj().Pkg() == nil {
+		return nil
+	}
+	if _, ok := named.Underlying().(*types.Struct); !ok {
+		return nil
+	}
+	rel, ok := d.dirs[named.Obj().Pkg().Path()]
+	if !ok {
+		return nil
+	}
+	name := named.Obj().Name()
+	if rel == "." {
+		return &Model{Folder: "./", Struct: name, Name: name}
+	}
+	return &Model{Folder: "./" + rel, Struct: name, Name: named.Obj().Pkg().Name() + "." + name}
+}
+
+// infoOf returns the type information of the package declaring fn.
+func (d *discoverer) infoOf(fn *types.Func) *types.Info {
+	for _, pkg := range d.pkgs {
+		if pkg.Types == fn.Pkg() {
+			return pkg.TypesInfo
+		}
+	}
+	return &types.Info{}
+}
+
+// calledFunc returns the function or method call invokes, or nil.
+func calledFunc(info *types.Info, call *ast.CallExpr) *types.Func {
+	var id *ast.Ident
+	switch fun := ast.Unparen(call.Fun).(type) {
+	case *ast.Ident:
+		id = fun
+	case *ast.SelectorExpr:
+		id = fun.Sel
+	default:
+		return nil
+	}
+	fn, _ := info.Uses[id].(*types.Func)
+	if fn == nil {
+		return nil
+	}
+	return fn.Origin()
+}
+
+// stringValue returns the value of a constant string expression.
+func stringValue(info *types.Info, expr ast.Expr) (string, bool) {
+	tv, ok := info.Types[expr]
+	if !ok || tv.Value == nil || tv.Value.Kind() != constant.String {
+		return "", false
+	}
+	return constant.StringVal(tv.Value), true
+}
@@ -0,0 +1,217 @@
+package generator
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// stdImports maps the package names generated code refers to onto their
+// import paths.
+var stdImports = map[string]string{
+	"bytes":   "bytes",
+	"fmt":     "fmt",
+	"http":    "net/http",
+	"json":    "encoding/json",
+	"os":      "os",
+	"require": "github.com/stretchr/testify/require",
+	"strconv": "strconv",
+	"strings": "strings",
+	"testing": "testing",
+	"time":    "time",
+	"url":     "net/url",
+}
+
+// modulePath returns the module path declared by the go.mod in dir.
+func modulePath(dir string) (string, error) {
+	file := filepath.Join(dir, "go.mod")
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("error reading %s :%v", file, err)
+	}
+	path := modfile.ModulePath(data)
+	if path == "" {
+		return "", fmt.Errorf("no module path in %s", file)
+	}
+	return path, nil
+}
+
+// importPaths returns the import paths of the packages generated code may
+// refer to by name: the standard ones and the packages of the annotated
+// models, resolved against the module path of BaseDir.
+func (j *JsonFile) importPaths() map[string]string {
+	imports := make(map[string]string, len(stdImports))
+	for name, path := range stdImports {
+		imports[name] = path
+	}
+	mod, err := modulePath(j.BaseDir)
+	if err != nil {
+		return imports
+	}
+	add := func(m *Model) {
+		if m == nil {
+			return
+		}
+		name, _, ok := strings.Cut(m.Name, ".")
+		dir := strings.Trim(strings.TrimPrefix(m.Folder, "./"), "/")
+		if !ok || dir == "" || dir == "." {
+			return
+		}
+		imports[name] = path.Join(mod, dir)
+	}
+	for _, route := range j.routes {
+		add(route.Default)
+		add(route.Patch)
+		for _, m := range route.Methods {
+			add(m.Request)
+			add(m.Response)
+		}
+	}
+	return imports
+}
+
+// formatFile adds the imports of the packages src refers to, removes the
+// ones it doesn't use and formats it. imports maps package names to their
+// paths; references to unknown names are left for the compiler to report.
+func formatFile(src []byte, imports map[string]string) ([]byte, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	used := usedPackages(fset, f)
+
+	specs := make([]importSpec, 0, len(f.Imports))
+	imported := make(map[string]bool)
+	for _, imp := range slices.Clone(f.Imports) {
+		p, err := strconv.Unquote(imp.Path.Value)
+		if err != nil {
+			continue
+		}
+		spec := importSpec{path: p}
+		if imp.Name != nil {
+			spec.name = imp.Name.Name
+		}
+		astutil.DeleteNamedImport(fset, f, spec.name, p)
+		name := cmp.Or(spec.name, importName(p))
+		if name != "_" && name != "." && !used[name] {
+			continue
+		}
+		specs = append(specs, spec)
+		imported[name] = true
+	}
+	for name := range used {
+		p, ok := imports[name]
+		if !ok || imported[name] {
+			continue
+		}
+		spec := importSpec{path: p}
+		if importName(p) != name {
+			spec.name = name
+		}
+		specs = append(specs, spec)
+	}
+
+	var buf bytes.Buffer
+	if err := format.Node(&buf, fset, f); err != nil {
+		return nil, err
+	}
+	out := buf.Bytes()
+	if len(specs) > 0 {
+		clause, err := parser.ParseFile(token.NewFileSet(), "", out, parser.PackageClauseOnly)
+		if err != nil {
+			return nil, err
+		}
+		end := clause.Name.End() - 1
+		out = slices.Concat(out[:end], []byte("\n\n"+importDecl(specs)), out[end:])
+	}
+	return format.Source(out)
+}
+
+// usedPackages returns the names of the packages f refers to. The file is
+// type checked on its own, without its imports: selectors on imported
+// package names and on names that resolve to nothing, which are missing
+// imports or declarations of the other files of the package, count as used.
+func usedPackages(fset *token.FileSet, f *ast.File) map[string]bool {
+	info := &types.Info{
+		Defs: make(map[*ast.Ident]types.Object),
+		Uses: make(map[*ast.Ident]types.Object),
+	}
+	cfg := types.Config{
+		Importer: importerFunc(func(path string) (*types.Package, error) {
+			return nil, fmt.Errorf("%s not loaded", path)
+		}),
+		Error: func(error) {},
+	}
+	// errors are expected, the file is incomplete on its own
+	cfg.Check(f.Name.Name, fset, []*ast.File{f}, info)
+
+	used := make(map[string]bool)
+	ast.Inspect(f, func(n ast.Node) bool {
+		sel, ok := n.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		id, ok := sel.X.(*ast.Ident)
+		if !ok {
+			return true
+		}
+		switch info.Uses[id].(type) {
+		case *types.PkgName:
+			used[id.Name] = true
+		case nil:
+			if info.Defs[id] == nil {
+				used[id.Name] = true
+			}
+		}
+		return true
+	})
+	return used
+}
+
+type importerFunc func(path string) (*types.Package, error)
+
+func (f importerFunc) Import(path string) (*types.Package, error) {
+	return f(path)
+}
+
+type importSpec struct {
+	name string
+	path string
+}
+
+// importDecl writes the import declaration of specs, standard library
+// packages first.
+func importDecl(specs []importSpec) string {
+	slices.SortFunc(specs, func(a, b importSpec) int { return strings.Compare(a.path, b.path) })
+	var std, other strings.Builder
+	for _, s := range specs {
+		sb := &other
+		if first, _, _ := strings.Cut(s.path, "/"); !strings.Contains(first, ".") {
+			sb = &std
+		}
+		if s.name != "" {
+			fmt.Fprintf(sb, "%s ", s.name)
+		}
+		fmt.Fprintf(sb, "%q\n", s.path)
+	}
+	if std.Len() > 0 && other.Len() > 0 {
+		std.WriteString("\n")
+	}
+	return "import (\n" + std.String() + other.String() + ")"
+}
@@ -0,0 +1,212 @@
+package generator
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"go/types"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+
+	"golang.org/x/mod/modfile"
+)
+
+// helpersFileName is the file of the request and assertion helpers, owned by
+// testgen and rewritten on every run.
+const helpersFileName = "testgen_helpers.go"
+
+// harness is how the generated helpers drive an app of one framework.
+type harness struct {
+	// Import is the package providing AppType.
+	Import  string
+	AppType string
+	// Test is the call sending req to an app without ServeHTTP, it is empty
+	// for http.Handler frameworks.
+	Test string
+}
+
+var harnesses = map[string]harness{
+	"nethttp": {Import: "net/http", AppType: "http.Handler"},
+	"chi":     {Import: "github.com/go-chi/chi/v5", AppType: "*chi.Mux"},
+	"gin":     {Import: "github.com/gin-gonic/gin", AppType: "*gin.Engine"},
+	"echo":    {Import: "github.com/labstack/echo/v4", AppType: "*echo.Echo"},
+	// fiber v2 times out after a second by default, -1 waits for the handler
+	"fiber2": {Import: "github.com/gofiber/fiber/v2", AppType: "*fiber.App", Test: "app.Test(req, -1)"},
+	"fiber3": {Import: "github.com/gofiber/fiber/v3", AppType: "*fiber.App", Test: "app.Test(req)"},
+}
+
+// Frameworks lists the supported --framework values.
+func Frameworks() []string {
+	names := make([]string, 0, len(harnesses))
+	for name := range harnesses {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// frameworkModules maps module paths found in go.mod to frameworks, in the
+// order they are looked for.
+var frameworkModules = []struct {
+	module    string
+	framework string
+}{
+	{"github.com/gofiber/fiber/v3", "fiber3"},
+	{"github.com/gofiber/fiber/v2", "fiber2"},
+	{"github.com/gin-gonic/gin", "gin"},
+	{"github.com/labstack/echo/v4", "echo"},
+	{"github.com/go-chi/chi/v5", "chi"},
+}
+
+// DetectFramework returns the framework required by the go.mod in dir, or
+// nethttp when it requires none of them.
+func DetectFramework(dir string) (string, error) {
+	path := filepath.Join(dir, "go.mod")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("error reading %s :%v", path, err)
+	}
+	f, err := modfile.ParseLax(path, data, nil)
+	if err != nil {
+		return "", fmt.Errorf("error parsing %s :%v", path, err)
+	}
+	for _, fm := range frameworkModules {
+		for _, req := range f.Require {
+			if req.Mod.Path == fm.module {
+				return fm.framework, nil
+			}
+		}
+	}
+	return "nethttp", nil
+}
+
+// harness returns the harness of the configured framework, detecting it from
+// go.mod when none is set.
+func (j *JsonFile) harness() (harness, error) {
+	name := strings.ToLower(j.Framework)
+	if name == "" {
+		detected, err := DetectFramework(j.BaseDir)
+		if err != nil {
+			slog.Warn("error detecting framework, using nethttp", "err", err)
+			detected = "nethttp"
+		}
+		name = detected
+	}
+	h, ok := harnesses[name]
+	if !ok {
+		return harness{}, fmt.Errorf("unknown framework %q, use one of %s", j.Framework, strings.Join(Frameworks(), ", "))
+	}
+	return h, nil
+}
+
+// setupType returns the app type returned by the setup function of a
+// harness file, or an empty string when it has none.
+func setupType(path string) string {
+	f, err := parser.ParseFile(token.NewFileSet(), path, nil, parser.SkipObjectResolution)
+	if err != nil {
+		return ""
+	}
+	for _, decl := range f.Decls {
+		fd, ok := decl.(*ast.FuncDecl)
+		if ok && fd.Recv == nil && fd.Name.Name == "setup" && fd.Type.Results != nil && len(fd.Type.Results.List) == 1 {
+			return types.ExprString(fd.Type.Results.List[0].Type)
+		}
+	}
+	return ""
+}
+
+// ownDecls returns the top-level declarations of the other files of the
+// gentest directory by name, with their signature for functions.
+func ownDecls() map[string]string {
+	decls := make(map[string]string)
+	paths, _ := filepath.Glob(filepath.Join(testFileDir, "*.go"))
+	for _, path := range paths {
+		if filepath.Base(path) == helpersFileName {
+			continue
+		}
+		fset := token.NewFileSet()
+		f, err := parser.ParseFile(fset, path, nil, parser.SkipObjectResolution)
+		if err != nil {
+			continue
+		}
+		for _, decl := range f.Decls {
+			for _, name := range declNames(decl) {
+				decls[name] = signature(fset, decl)
+			}
+		}
+	}
+	return decls
+}
+
+func signature(fset *token.FileSet, decl ast.Decl) string {
+	fd, ok := decl.(*ast.FuncDecl)
+	if !ok {
+		return ""
+	}
+	var buf bytes.Buffer
+	printer.Fprint(&buf, fset, fd.Type)
+	return buf.String()
+}
+
+// writeHelpers writes the helpers of the generated tests. Helpers declared
+// in the other gentest files, such as a testutils.go written by older
+// versions, are left out so they keep working; a warning is logged when
+// their signature differs from testgen's.
+func writeHelpers(tmpl *template.Template, h harness) error {
+	src, err := render(tmpl, h)
+	if err != nil {
+		return err
+	}
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, helpersFileName, src, parser.ParseComments)
+	if err != nil {
+		return fmt.Errorf("error parsing %s :%v", helpersFileName, err)
+	}
+	own := ownDecls()
+	decls := f.Decls[:0]
+	for _, decl := range f.Decls {
+		var dropped bool
+		for _, name := range declNames(decl) {
+			sig, ok := own[name]
+			if !ok {
+				continue
+			}
+			dropped = true
+			if want := signature(fset, decl); sig != want {
+				slog.Warn("helper declared in gentest differs from testgen's, generated tests may not compile", "name", name, "have", sig, "want", want)
+			}
+		}
+		if !dropped {
+			decls = append(decls, decl)
+			continue
+		}
+		start := decl.Pos()
+		if fd, ok := decl.(*ast.FuncDecl); ok && fd.Doc != nil {
+			start = fd.Doc.Pos()
+		} else if gd, ok := decl.(*ast.GenDecl); ok && gd.Doc != nil {
+			start = gd.Doc.Pos()
+		}
+		f.Comments = slices.DeleteFunc(f.Comments, func(c *ast.CommentGroup) bool {
+			return c.Pos() >= start && c.End() <= decl.End()
+		})
+	}
+	f.Decls = decls
+
+	var buf bytes.Buffer
+	if err := format.Node(&buf, fset, f); err != nil {
+		return err
+	}
+	out, err := formatFile(buf.Bytes(), nil)
+	if err != nil {
+		return fmt.Errorf("error formatting %s :%v", helpersFileName, err)
+	}
+	return os.WriteFile(filepath.Join(testFileDir, helpersFileName), out, 0o644)
+}
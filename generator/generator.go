@@ -1,106 +1,277 @@
 package generator
 
 import (
-	"bufio"
+	"errors"
 	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
 	"io/fs"
 	"log/slog"
-	"os"
 	"path/filepath"
 	"strings"
 )
 
 type Scanner struct {
 	InputDir string
+	// Discover also reads the routes registered in the router setup code, see
+	// DiscoverRoutes. Annotations take precedence over discovered routes.
+	Discover bool
 }
 
-func (s *Scanner) scanFile(path string) ([]string, error) {
-	results := make([]string, 0)
-	f, err := os.Open(path)
-	if err != nil {
-		return []string{}, err
+// Diagnostic is a problem with an annotation, reported at the position of
+// its @testgen marker.
+type Diagnostic struct {
+	Pos token.Position
+	Msg string
+}
+
+func (d Diagnostic) Error() string {
+	return fmt.Sprintf("%s: %s", d.Pos, d.Msg)
+}
+
+// Diagnostics lists the problems found while scanning annotations, in file
+// order.
+type Diagnostics []Diagnostic
+
+func (d Diagnostics) Error() string {
+	lines := make([]string, 0, len(d))
+	for _, diag := range d {
+		lines = append(lines, diag.Error())
 	}
-	defer f.Close()
+	return strings.Join(lines, "\n")
+}
+
+// annotationComment is a @testgen line found in a comment.
+type annotationComment struct {
+	text string
+	pos  token.Position
+	// typ is the type declared right below the comment group, if any.
+	typ *Model
+}
 
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.Contains(line, "@testgen") {
-			results = append(results, line)
+// scanFile returns the @testgen annotations written in the comments of a Go
+// file. Only comment lines starting with @testgen count, so the marker in
+// string literals or in the middle of a sentence is ignored.
+func (s *Scanner) scanFile(fset *token.FileSet, path string) ([]annotationComment, error) {
+	f, err := parser.ParseFile(fset, path, nil, parser.ParseComments|parser.SkipObjectResolution)
+	if err != nil {
+		return nil, err
+	}
+	types := s.docTypes(f, path)
+	results := make([]annotationComment, 0)
+	for _, cg := range f.Comments {
+		for _, c := range cg.List {
+			for _, line := range commentLines(c.Text) {
+				trimmed := strings.TrimLeft(line.text, " \t*")
+				if !strings.HasPrefix(trimmed, "@testgen") {
+					continue
+				}
+				col := line.offset + len(line.text) - len(trimmed)
+				results = append(results, annotationComment{
+					text: trimmed,
+					pos:  fset.Position(c.Slash + token.Pos(col)),
+					typ:  types[cg],
+				})
+			}
 		}
 	}
 	return results, nil
 }
 
-func (s *Scanner) getTags(str string) (string, string, error) {
-	str = strings.ReplaceAll(str, "//", "")
-	a := strings.SplitSeq(str, " ")
-	var endpoint string
-	var strct string
-	for i := range a {
-		if strings.Contains(i, "struct=") {
-			strct = strings.ReplaceAll(i, "struct=", "")
-		}
-		if strings.Contains(i, "router=") {
-			endpoint = strings.ReplaceAll(i, "router=", "")
-		}
+type commentLine struct {
+	offset int
+	text   string
+}
 
+// commentLines splits the text of a comment into its lines, along with their
+// byte offset in the comment.
+func commentLines(text string) []commentLine {
+	lines := make([]commentLine, 0)
+	body := strings.TrimPrefix(text, "//")
+	if strings.HasPrefix(text, "/*") {
+		body = strings.TrimSuffix(strings.TrimPrefix(text, "/*"), "*/")
 	}
-	if endpoint != "" && strct != "" {
-		return endpoint, strct, nil
+	offset := 2
+	for line := range strings.SplitSeq(body, "\n") {
+		lines = append(lines, commentLine{offset: offset, text: line})
+		offset += len(line) + 1
 	}
-	return "", "", fmt.Errorf("missing router or struct tag in the :%s", str)
+	return lines
 }
 
-func (s *Scanner) ScanTags() (map[string]map[string]string, error) {
-	resultsMap := make(map[string]map[string]string)
-	results := make(map[string]string)
-	tagList := make([]string, 0)
+// docTypes maps the doc comment of every type declaration in f to the type,
+// so annotations without struct= can refer to the type they document.
+func (s *Scanner) docTypes(f *ast.File, path string) map[*ast.CommentGroup]*Model {
+	folder := "./"
+	prefix := ""
+	if rel, err := filepath.Rel(s.InputDir, filepath.Dir(path)); err == nil && rel != "." {
+		folder = "./" + filepath.ToSlash(rel)
+		prefix = f.Name.Name + "."
+	}
+	types := make(map[*ast.CommentGroup]*Model)
+	for _, decl := range f.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok || gd.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gd.Specs {
+			ts := spec.(*ast.TypeSpec)
+			doc := ts.Doc
+			if doc == nil && len(gd.Specs) == 1 {
+				doc = gd.Doc
+			}
+			if doc != nil {
+				types[doc] = &Model{Folder: folder, Struct: ts.Name.Name, Name: prefix + ts.Name.Name}
+			}
+		}
+	}
+	return types
+}
+
+// ScanRoutes reads every @testgen annotation under InputDir and groups them
+// by the endpoint recordings are keyed by. Invalid and duplicate annotations
+// are skipped and returned as Diagnostics next to the routes that were read.
+func (s *Scanner) ScanRoutes() (map[string]*Route, error) {
+	routes := make(map[string]*Route)
+	fset := token.NewFileSet()
+	comments := make([]annotationComment, 0)
 	err := filepath.WalkDir(s.InputDir, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			slog.Error("error accessing path", "path", path, "err", err)
 			return nil
 		}
-		if d.IsDir() || !strings.HasSuffix(path, ".go") {
+		if d.IsDir() {
+			name := d.Name()
+			if path != s.InputDir && (name == "vendor" || name == "testdata" || strings.HasPrefix(name, ".")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(path, ".go") {
 			return nil
 		}
-		res, err := s.scanFile(path)
+		res, err := s.scanFile(fset, path)
 		if err != nil {
-			slog.Error("error scanning files", "path", path, "err", err)
-			return err
+			slog.Warn("skipping file that does not parse", "path", path, "err", err)
+			return nil
 		}
-		tagList = append(tagList, res...)
+		comments = append(comments, res...)
 		return nil
 	})
 	if err != nil {
-		return resultsMap, fmt.Errorf("error reading directory %s :%v", s.InputDir, err)
+		return routes, fmt.Errorf("error reading directory %s :%v", s.InputDir, err)
 	}
-	for _, i := range tagList {
-		router, strct, err := s.getTags(i)
+
+	var diags Diagnostics
+	for _, c := range comments {
+		a, err := parseAnnotation(c.text, c.typ)
+		if err == nil {
+			err = addAnnotation(routes, a)
+		}
 		if err != nil {
-			slog.Error("error extracting tags from string", "string", i, "err", err)
-			continue
+			diags = append(diags, Diagnostic{Pos: c.pos, Msg: err.Error()})
+		}
+	}
+
+	// discovered routes are merged once every annotation is known, so
+	// annotations win over them
+	if s.Discover {
+		discovered, err := s.DiscoverRoutes()
+		if err != nil {
+			slog.Warn("error discovering routes, using annotations only", "err", err)
 		}
-		results[router] = strct
+		mergeRoutes(routes, discovered)
+	}
+	if len(diags) > 0 {
+		return routes, diags
 	}
-	for key, item := range results {
-		var folder, strct string
-		if strings.Contains(item, ".") {
-			a := strings.Split(item, ".")
-			if len(a) >= 2 {
-				folder = "./" + a[0]
-				strct = a[1]
+	return routes, nil
+}
+
+// mergeRoutes adds the discovered routes to routes. Annotations take
+// precedence: discovered request structs are dropped for routes with a
+// struct= model, for PATCH when patch= is set and for methods annotated
+// with their own request struct.
+func mergeRoutes(routes, discovered map[string]*Route) {
+	for key, route := range discovered {
+		existing, ok := routes[key]
+		if !ok {
+			routes[key] = route
+			continue
+		}
+		for _, dm := range route.Methods {
+			if !existing.annotated(dm) {
+				existing.Methods = append(existing.Methods, dm)
 			}
-		} else {
-			folder = "./"
-			strct = item
+		}
+	}
+}
 
+// annotated reports whether the request bodies of a discovered method are
+// already covered by the annotations of r.
+func (r *Route) annotated(dm *MethodModels) bool {
+	if r.Default != nil {
+		return true
+	}
+	if r.Patch != nil && (dm.Method == "PATCH" || dm.Method == "") {
+		return true
+	}
+	for _, m := range r.Methods {
+		sameMethod := m.Method == dm.Method || m.Method == "" || dm.Method == ""
+		if m.Request != nil && sameMethod && (matchRouter(m.Router, dm.Router) || matchRouter(dm.Router, m.Router)) {
+			return true
 		}
-		resultsMap[key] = make(map[string]string)
-		resultsMap[key]["folder"] = folder
-		resultsMap[key]["struct"] = strct
-		resultsMap[key]["name"] = item
 	}
+	return false
+}
 
-	return resultsMap, nil
+// ScanTags returns the request struct of every route, keyed by endpoint, as
+// folder, struct and name entries. It is the struct= model, or the first
+// request struct annotated or discovered for one of the route's methods.
+func (s *Scanner) ScanTags() (map[string]map[string]string, error) {
+	resultsMap := make(map[string]map[string]string)
+	routes, err := s.ScanRoutes()
+	if err != nil && !errors.As(err, new(Diagnostics)) {
+		return resultsMap, err
+	}
+	for key, route := range routes {
+		model := route.Default
+		for _, m := range route.Methods {
+			if model == nil {
+				model = m.Request
+			}
+		}
+		if model == nil {
+			continue
+		}
+		resultsMap[key] = map[string]string{
+			"folder": model.Folder,
+			"struct": model.Struct,
+			"name":   model.Name,
+		}
+		if route.Patch != nil {
+			resultsMap[key]["patchFolder"] = route.Patch.Folder
+			resultsMap[key]["patchStruct"] = route.Patch.Struct
+			resultsMap[key]["patchName"] = route.Patch.Name
+		}
+	}
+	return resultsMap, err
+}
+
+// splitStruct splits pkg.Struct into the package folder and the struct name.
+func splitStruct(item string) (string, string) {
+	var folder, strct string
+	if strings.Contains(item, ".") {
+		a := strings.Split(item, ".")
+		if len(a) >= 2 {
+			folder = "./" + a[0]
+			strct = a[1]
+		}
+	} else {
+		folder = "./"
+		strct = item
+
+	}
+	return folder, strct
 }
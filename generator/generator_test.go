@@ -1,9 +1,16 @@
 package generator
 
 import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/muzzii255/testgen/proxy"
 	"github.com/stretchr/testify/require"
@@ -13,8 +20,21 @@ func TestScanner_scanFile(t *testing.T) {
 	tmpDir := t.TempDir()
 	testFile := filepath.Join(tmpDir, "test.go")
 	content := `package main
+
 // @testgen router=/api/users struct=User
-func main() {}
+func main() {
+	_ = "@testgen router=/api/fake struct=Fake"
+}
+
+/*
+ * @testgen router=/api/teams struct=Team
+ */
+
+// User is documented by an annotation.
+//	@testgen router=/api/users method=GET
+type User struct{}
+
+// not an annotation, mentions @testgen router=/api/nope
 `
 	err := os.WriteFile(testFile, []byte(content), 0o644)
 	if err != nil {
@@ -22,12 +42,20 @@ func main() {}
 	}
 
 	scanner := &Scanner{InputDir: tmpDir}
-	results, err := scanner.scanFile(testFile)
+	results, err := scanner.scanFile(token.NewFileSet(), testFile)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	require.Equal(t, 1, len(results))
-	require.Equal(t, "// @testgen router=/api/users struct=User", results[0])
+	require.Equal(t, 3, len(results))
+	require.Equal(t, "@testgen router=/api/users struct=User", results[0].text)
+	require.Equal(t, 3, results[0].pos.Line)
+	require.Equal(t, 4, results[0].pos.Column)
+	require.Nil(t, results[0].typ)
+	require.Equal(t, "@testgen router=/api/teams struct=Team", results[1].text)
+	require.Equal(t, 9, results[1].pos.Line)
+	require.Equal(t, 4, results[1].pos.Column)
+	require.Equal(t, 13, results[2].pos.Line)
+	require.Equal(t, &Model{Folder: "./", Struct: "User", Name: "User"}, results[2].typ)
 }
 
 func TestScanner_scanFileNoTags(t *testing.T) {
@@ -42,72 +70,387 @@ func main() {}
 	}
 
 	scanner := &Scanner{InputDir: tmpDir}
-	results, err := scanner.scanFile(testFile)
+	results, err := scanner.scanFile(token.NewFileSet(), testFile)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 	require.Equal(t, 0, len(results))
 }
 
-func TestScanner_getTags(t *testing.T) {
-	scanner := &Scanner{}
+func TestScanner_ScanRoutesAttached(t *testing.T) {
+	tmpDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(tmpDir, "internal", "models"), 0o755))
+	content := `package models
+
+// CreateUserReq is sent to create a user.
+// @testgen router=/api/users method=POST response=UserResp status=201
+type CreateUserReq struct{}
+
+type (
+	// @testgen router=/api/teams
+	Team struct{}
+)
+`
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "internal", "models", "models.go"), []byte(content), 0o644))
+
+	scanner := &Scanner{InputDir: tmpDir}
+	routes, err := scanner.ScanRoutes()
+	require.NoError(t, err)
+	req, _ := routes["/api/users"].RequestModel("POST", "/api/users")
+	require.Equal(t, &Model{Folder: "./internal/models", Struct: "CreateUserReq", Name: "models.CreateUserReq"}, req)
+	require.Equal(t, "models.Team", routes["/api/teams"].Default.Name)
+}
+
+func TestScanner_ScanRoutesDiagnostics(t *testing.T) {
+	tmpDir := t.TempDir()
+	content := `package main
+
+// @testgen router=/api/users struct=User
+// @testgen router=/api/users struct=Other
+// @testgen router=/api/teams struct=Team colour=blue
+//   @testgen router=/api/orders
+func main() {}
+`
+	testFile := filepath.Join(tmpDir, "main.go")
+	require.NoError(t, os.WriteFile(testFile, []byte(content), 0o644))
+
+	scanner := &Scanner{InputDir: tmpDir}
+	routes, err := scanner.ScanRoutes()
+	var diags Diagnostics
+	require.ErrorAs(t, err, &diags)
+	require.Len(t, diags, 3)
+	require.Equal(t, testFile+":4:4: duplicate struct for router /api/users", diags[0].Error())
+	require.Equal(t, testFile+`:5:4: unknown annotation key "colour"`, diags[1].Error())
+	require.Equal(t, testFile+":6:6: missing struct, request or response key and no type declared below", diags[2].Error())
+	// valid annotations are still read
+	require.Equal(t, "User", routes["/api/users"].Default.Name)
+
+	tags, err := scanner.ScanTags()
+	require.Error(t, err)
+	require.Equal(t, "User", tags["/api/users"]["name"])
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+}
+
+func TestScanner_DiscoverRoutes(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod": `module example.com/app
+
+go 1.25
+
+require (
+	github.com/gin-gonic/gin v1.0.0
+	github.com/go-chi/chi/v5 v5.0.0
+	github.com/gofiber/fiber/v2 v2.0.0
+	github.com/labstack/echo/v4 v4.0.0
+)
+
+replace github.com/gin-gonic/gin => ./stubs/gin
+
+replace github.com/go-chi/chi/v5 => ./stubs/chi
+
+replace github.com/gofiber/fiber/v2 => ./stubs/fiber
+
+replace github.com/labstack/echo/v4 => ./stubs/echo
+`,
+		"stubs/gin/go.mod": "module github.com/gin-gonic/gin\n\ngo 1.25\n",
+		"stubs/gin/gin.go": `package gin
+
+type Context struct{}
+
+func (c *Context) ShouldBindJSON(v any) error { return nil }
+
+type HandlerFunc func(*Context)
+
+type RouterGroup struct{}
+
+func (g *RouterGroup) Group(prefix string, h ...HandlerFunc) *RouterGroup { return g }
+func (g *RouterGroup) POST(path string, h ...HandlerFunc)                  {}
+func (g *RouterGroup) PUT(path string, h ...HandlerFunc)                   {}
+
+type Engine struct{ RouterGroup }
+
+func New() *Engine { return &Engine{} }
+`,
+		"stubs/chi/go.mod": "module github.com/go-chi/chi/v5\n\ngo 1.25\n",
+		"stubs/chi/chi.go": `package chi
+
+import "net/http"
+
+type Router interface {
+	http.Handler
+	Route(pattern string, fn func(r Router)) Router
+	Mount(pattern string, h http.Handler)
+	With(middlewares ...func(http.Handler) http.Handler) Router
+	Post(pattern string, h http.HandlerFunc)
+}
+
+func NewRouter() Router { return nil }
+`,
+		"stubs/echo/go.mod": "module github.com/labstack/echo/v4\n\ngo 1.25\n",
+		"stubs/echo/echo.go": `package echo
+
+type Context interface {
+	Bind(i any) error
+}
+
+type HandlerFunc func(c Context) error
+
+type Group struct{}
+
+func (g *Group) POST(path string, h HandlerFunc) {}
+
+type Echo struct{}
+
+func New() *Echo { return &Echo{} }
+
+func (e *Echo) Group(prefix string) *Group          { return &Group{} }
+func (e *Echo) Add(method, path string, h HandlerFunc) {}
+`,
+		"stubs/fiber/go.mod": "module github.com/gofiber/fiber/v2\n\ngo 1.25\n",
+		"stubs/fiber/fiber.go": `package fiber
+
+type Ctx struct{}
+
+func (c *Ctx) BodyParser(out any) error { return nil }
+
+type Handler func(c *Ctx) error
+
+type Router interface {
+	Post(path string, h ...Handler) Router
+}
+
+type App struct{}
+
+func New() *App { return &App{} }
+
+func (a *App) Group(prefix string, h ...Handler) Router { return a }
+func (a *App) Mount(prefix string, sub *App) Router      { return a }
+func (a *App) Post(path string, h ...Handler) Router     { return a }
+`,
+		"models/models.go": `package models
+
+type CreateUserReq struct {
+	Name string ` + "`json:\"name\"`" + `
+}
+
+type User struct {
+	Name string ` + "`json:\"name\"`" + `
+}
+
+type Team struct {
+	Name string ` + "`json:\"name\"`" + `
+}
+`,
+		"main.go": `package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"example.com/app/models"
+	"github.com/gin-gonic/gin"
+	"github.com/go-chi/chi/v5"
+	"github.com/gofiber/fiber/v2"
+	"github.com/labstack/echo/v4"
+)
+
+func createUser(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateUserReq
+	json.NewDecoder(r.Body).Decode(&req)
+}
+
+type handler struct{}
+
+func (h *handler) update(w http.ResponseWriter, r *http.Request) {
+	req := &models.User{}
+	_ = json.NewDecoder(r.Body).Decode(req)
+}
+
+func auth(next http.HandlerFunc) http.HandlerFunc { return next }
+
+func teamRoutes(g *gin.RouterGroup) {
+	g.POST("/teams", func(c *gin.Context) {
+		var team models.Team
+		c.ShouldBindJSON(&team)
+	})
+}
+
+func adminRouter() chi.Router {
+	r := chi.NewRouter()
+	r.Post("/teams", func(w http.ResponseWriter, req *http.Request) {
+		var team models.Team
+		json.NewDecoder(req.Body).Decode(&team)
+	})
+	return r
+}
+
+func logged(next http.Handler) http.Handler { return next }
+
+func main() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/users", createUser)
+	h := &handler{}
+	mux.Handle("PUT /api/users/{id}", auth(h.update))
+	mux.HandleFunc("/api/health", func(w http.ResponseWriter, r *http.Request) {})
+
+	r := gin.New()
+	v1 := r.Group("/gin")
+	teamRoutes(v1)
+
+	c := chi.NewRouter()
+	c.Route("/chi", func(r chi.Router) {
+		r.Post("/users/{id}/teams", func(w http.ResponseWriter, req *http.Request) {
+			var team models.Team
+			json.NewDecoder(req.Body).Decode(&team)
+		})
+		r.With(logged).Post("/orders", createUser)
+	})
+	c.Mount("/admin", adminRouter())
+
+	e := echo.New()
+	e.Group("/echo").POST("/teams", func(c echo.Context) error {
+		var team models.Team
+		return c.Bind(&team)
+	})
+	e.Add("PUT", "/echo/users/:id", func(c echo.Context) error {
+		user := new(models.User)
+		return c.Bind(user)
+	})
+
+	app := fiber.New()
+	app.Group("/fiber").Post("/users", func(c *fiber.Ctx) error {
+		var user models.User
+		return c.BodyParser(&user)
+	})
+	billing := fiber.New()
+	billing.Post("/teams", func(c *fiber.Ctx) error {
+		var team models.Team
+		return c.BodyParser(&team)
+	})
+	app.Mount("/billing", billing)
+}
+`,
+	})
+
+	scanner := &Scanner{InputDir: dir}
+	routes, err := scanner.DiscoverRoutes()
+	require.NoError(t, err)
+	require.Len(t, routes, 9)
+
+	req, _ := routes["/api/users"].RequestModel("POST", "/api/users")
+	require.Equal(t, &Model{Folder: "./models", Struct: "CreateUserReq", Name: "models.CreateUserReq"}, req)
+	req, _ = routes["/api/users"].RequestModel("PUT", "/api/users/:id")
+	require.Equal(t, "models.User", req.Name)
+	req, _ = routes["/gin/teams"].RequestModel("POST", "/gin/teams")
+	require.Equal(t, "models.Team", req.Name)
+	req, _ = routes["/chi/users/teams"].RequestModel("POST", "/chi/users/:id/teams")
+	require.Equal(t, "models.Team", req.Name)
+	req, _ = routes["/chi/orders"].RequestModel("POST", "/chi/orders")
+	require.Equal(t, "models.CreateUserReq", req.Name)
+	req, _ = routes["/admin/teams"].RequestModel("POST", "/admin/teams")
+	require.Equal(t, "models.Team", req.Name)
+	req, _ = routes["/echo/teams"].RequestModel("POST", "/echo/teams")
+	require.Equal(t, "models.Team", req.Name)
+	req, _ = routes["/echo/users"].RequestModel("PUT", "/echo/users/:id")
+	require.Equal(t, "models.User", req.Name)
+	req, _ = routes["/fiber/users"].RequestModel("POST", "/fiber/users")
+	require.Equal(t, "models.User", req.Name)
+	req, _ = routes["/billing/teams"].RequestModel("POST", "/billing/teams")
+	require.Equal(t, "models.Team", req.Name)
+	require.Nil(t, routes["/api/health"])
+
+	// annotations win over discovered routes
+	writeFiles(t, dir, map[string]string{
+		"models/annotations.go": "package models\n\n// @testgen router=/api/users method=POST request=models.User\n",
+	})
+	scanner.Discover = true
+	routes, err = scanner.ScanRoutes()
+	require.NoError(t, err)
+	req, _ = routes["/api/users"].RequestModel("POST", "/api/users")
+	require.Equal(t, "models.User", req.Name)
+	require.NotNil(t, routes["/gin/teams"])
 
+	// discovered routes reach the route to struct map
+	tags, err := scanner.ScanTags()
+	require.NoError(t, err)
+	require.Equal(t, "models.Team", tags["/gin/teams"]["name"])
+	require.Equal(t, "./models", tags["/gin/teams"]["folder"])
+}
+
+func TestMergeRoutes(t *testing.T) {
+	discovered := func() map[string]*Route {
+		return map[string]*Route{"/api/users": {Router: "/api/users", Methods: []*MethodModels{
+			{Router: "/api/users", Method: "POST", Request: newModel("models.CreateUserReq")},
+			{Router: "/api/users/{id}", Method: "", Request: newModel("models.UserInput")},
+		}}}
+	}
 	tests := []struct {
-		name       string
-		input      string
-		wantRouter string
-		wantStruct string
-		wantErr    bool
+		name  string
+		route *Route
+		// request structs of POST /api/users and PATCH /api/users/:id
+		post, patch string
 	}{
-		{
-			name:       "valid tags",
-			input:      " @testgen router=/api/users struct=User",
-			wantRouter: "/api/users",
-			wantStruct: "User",
-			wantErr:    false,
-		},
-		{
-			name:       "valid tags with package",
-			input:      " @testgen router=/api/users struct=models.User",
-			wantRouter: "/api/users",
-			wantStruct: "models.User",
-			wantErr:    false,
-		},
-		{
-			name:       "missing router",
-			input:      " @testgen struct=User",
-			wantRouter: "",
-			wantStruct: "",
-			wantErr:    true,
-		},
-		{
-			name:       "missing struct",
-			input:      " @testgen router=/api/users",
-			wantRouter: "",
-			wantStruct: "",
-			wantErr:    true,
-		},
-		{
-			name:       "empty string",
-			input:      "",
-			wantRouter: "",
-			wantStruct: "",
-			wantErr:    true,
-		},
+		{"struct wins", &Route{Default: newModel("models.User")}, "models.User", "models.User"},
+		{"patch wins", &Route{Patch: newModel("models.UserPatch")}, "models.CreateUserReq", "models.UserPatch"},
+		{"method wins", &Route{Methods: []*MethodModels{
+			{Router: "/api/users", Method: "POST", Request: newModel("models.NewUser")},
+		}}, "models.NewUser", "models.UserInput"},
+		{"response only", &Route{Methods: []*MethodModels{
+			{Router: "/api/users", Method: "POST", Response: newModel("models.UserResp")},
+		}}, "models.CreateUserReq", "models.UserInput"},
 	}
-
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gotRouter, gotStruct, err := scanner.getTags(tt.input)
-			if tt.wantErr {
-				require.Error(t, err)
-			} else {
-				require.NoError(t, err)
-			}
-			require.Equal(t, tt.wantRouter, gotRouter)
-			require.Equal(t, tt.wantStruct, gotStruct)
+			routes := map[string]*Route{"/api/users": tt.route}
+			mergeRoutes(routes, discovered())
+			post, _ := routes["/api/users"].RequestModel("POST", "/api/users")
+			require.Equal(t, tt.post, post.Name)
+			patch, _ := routes["/api/users"].RequestModel("PATCH", "/api/users/:id")
+			require.Equal(t, tt.patch, patch.Name)
 		})
 	}
+
+	routes := map[string]*Route{}
+	mergeRoutes(routes, discovered())
+	require.Len(t, routes["/api/users"].Methods, 2)
+}
+
+func TestSplitServeMuxPattern(t *testing.T) {
+	tests := []struct {
+		pattern, method, path string
+	}{
+		{"POST /users", "POST", "/users"},
+		{"GET example.com/users/{id}", "GET", "/users/{id}"},
+		{"/health", "", "/health"},
+		{"GET /{$}", "GET", "/"},
+	}
+	for _, tt := range tests {
+		method, path := splitServeMuxPattern(tt.pattern)
+		require.Equal(t, tt.method, method, tt.pattern)
+		require.Equal(t, tt.path, path, tt.pattern)
+	}
+}
+
+func TestAnnotationFields(t *testing.T) {
+	fields, err := annotationFields(" router=/api/users\tstruct=\"models.User\"  method=POST ")
+	require.NoError(t, err)
+	require.Equal(t, []string{"router=/api/users", "struct=models.User", "method=POST"}, fields)
+
+	fields, err = annotationFields(`router="/api/a b" struct=User`)
+	require.NoError(t, err)
+	require.Equal(t, []string{"router=/api/a b", "struct=User"}, fields)
+
+	_, err = annotationFields(`router="/api/users struct=User`)
+	require.Error(t, err)
 }
 
 func TestScanner_ScanTags(t *testing.T) {
@@ -139,6 +482,24 @@ func TestScanner_ScanTags(t *testing.T) {
 	require.Equal(t, "models.User", data["name"])
 }
 
+func TestScanner_ScanTagsPatch(t *testing.T) {
+	tmpDir := t.TempDir()
+	content := `package main
+// @testgen router=/api/users struct=models.User patch=models.UserPatch
+// @testgen router=/api/teams struct=Team
+func main() {}
+`
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "test.go"), []byte(content), 0o644))
+
+	scanner := &Scanner{InputDir: tmpDir}
+	results, err := scanner.ScanTags()
+	require.NoError(t, err)
+	require.Equal(t, "./models", results["/api/users"]["patchFolder"])
+	require.Equal(t, "UserPatch", results["/api/users"]["patchStruct"])
+	require.Equal(t, "models.UserPatch", results["/api/users"]["patchName"])
+	require.NotContains(t, results["/api/teams"], "patchName")
+}
+
 func TestScanner_ScanTagsNoFolder(t *testing.T) {
 	tmpDir := t.TempDir()
 	testFile := filepath.Join(tmpDir, "test.go")
@@ -164,6 +525,422 @@ func main() {}
 	require.Equal(t, "Health", data["struct"])
 }
 
+func TestParseAnnotation(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    annotation
+		wantErr bool
+	}{
+		{"struct", "// @testgen router=/api/users struct=models.User", annotation{router: "/api/users", strct: "models.User"}, false},
+		{"method", "// @testgen router=/users method=post request=CreateUserReq response=UserResp status=201", annotation{router: "/users", method: "POST", request: "CreateUserReq", response: "UserResp", status: 201}, false},
+		{"tabs", "//\t@testgen\trouter=/users\tmethod=GET response=UserResp", annotation{router: "/users", method: "GET", response: "UserResp"}, false},
+		{"missing router", "// @testgen struct=User", annotation{}, true},
+		{"missing struct", "// @testgen router=/users", annotation{}, true},
+		{"unknown key", "// @testgen router=/users struct=User strict=true", annotation{}, true},
+		{"malformed", "// @testgen router=/users struct", annotation{}, true},
+		{"bad status", "// @testgen router=/users method=POST response=UserResp status=20x", annotation{}, true},
+		{"response without method", "// @testgen router=/users response=UserResp", annotation{}, true},
+		{"struct and request", "// @testgen router=/users method=POST struct=User request=CreateUserReq", annotation{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseAnnotation(tt.input, nil)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRouteKey(t *testing.T) {
+	require.Equal(t, "/api/users", routeKey("/api/users"))
+	require.Equal(t, "/api/users", routeKey("/api/users/:id"))
+	require.Equal(t, "/api/users/posts", routeKey("/api/users/{id}/posts/{postID}"))
+	require.Equal(t, "/", routeKey("/"))
+}
+
+func TestScanner_ScanRoutes(t *testing.T) {
+	tmpDir := t.TempDir()
+	content := `package main
+// @testgen router=/api/users struct=models.User
+// @testgen router=/api/users method=POST request=models.CreateUserReq response=models.UserResp status=201
+// @testgen router=/api/users/:id method=GET response=models.UserResp
+// @testgen router=/api/users method=GET response=models.UserList
+// @testgen router=/api/users/:id method=GET response=models.Other
+func main() {}
+`
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "test.go"), []byte(content), 0o644))
+
+	scanner := &Scanner{InputDir: tmpDir}
+	routes, err := scanner.ScanRoutes()
+	var diags Diagnostics
+	require.ErrorAs(t, err, &diags)
+	require.Len(t, diags, 1)
+	require.Len(t, routes, 1)
+	route := routes["/api/users"]
+	require.Equal(t, "models.User", route.Default.Name)
+	// the duplicate GET on /api/users/:id is dropped
+	require.Len(t, route.Methods, 3)
+
+	req, partial := route.RequestModel("POST", "/api/users")
+	require.Equal(t, "models.CreateUserReq", req.Name)
+	require.False(t, partial)
+	req, _ = route.RequestModel("PUT", "/api/users/:id")
+	require.Equal(t, "models.User", req.Name)
+	req, partial = route.RequestModel("PATCH", "/api/users/:id")
+	require.Equal(t, "models.User", req.Name)
+	require.True(t, partial)
+
+	require.Equal(t, "models.UserResp", route.ResponseModel("POST", "/api/users", 201).Name)
+	require.Nil(t, route.ResponseModel("POST", "/api/users", 200))
+	require.Equal(t, "models.UserResp", route.ResponseModel("GET", "/api/users/:id", 200).Name)
+	require.Equal(t, "models.UserList", route.ResponseModel("GET", "/api/users", 200).Name)
+	require.Nil(t, route.ResponseModel("GET", "/api/users/:id", 404))
+
+	tags, _ := scanner.ScanTags()
+	require.Equal(t, "models.User", tags["/api/users"]["name"])
+}
+
+func TestResponseCheck(t *testing.T) {
+	route := &Route{Methods: []*MethodModels{
+		{Router: "/api/users", Method: "POST", Response: newModel("models.UserResp"), Status: 201},
+		{Router: "/api/users/:id", Method: "GET", Response: newModel("models.UserResp")},
+	}}
+	rows := []proxy.BodyRecords{
+		{Method: "POST", StatusCode: 201, Path: "/api/users", ResponseBody: `{"id":"u_123456"}`},
+		{Method: "GET", StatusCode: 200, Path: "/api/users/:id", RawPath: "/api/users/u_123456", PathParams: []proxy.PathParam{{Name: "id", Value: "u_123456"}}, ResponseBody: `{"id":"u_123456"}`},
+		{Method: "GET", StatusCode: 404, Path: "/api/users/:id", RawPath: "/api/users/u_1", ResponseBody: `{"error":"not found"}`},
+	}
+	j := &JsonFile{routes: map[string]*Route{"/api/users": route}}
+	j.chain = planChain([]*proxy.BodyRecords{&rows[0], &rows[1], &rows[2]}, []string{"/api/users", "/api/users", "/api/users"})
+
+	require.Equal(t, "_, body := decodeResp[models.UserResp](t, resp)\nvar created struct {\nID string `json:\"id\"`\n}\nrequire.NoError(t, json.Unmarshal(body, &created))\nusersID = created.ID\nrequireJSON(t, body, `{\"id\":\"<string>\"}`)\n", j.responseCheck("users 0", "/api/users", &rows[0]))
+	require.Equal(t, "_, body := decodeResp[models.UserResp](t, resp)\nrequireJSON(t, body, `{\"id\":\"<string>\"}`)\n", j.responseCheck("users 0", "/api/users", &rows[1]))
+	require.Equal(t, "_, body := decodeResp[any](t, resp)\nrequireJSON(t, body, `{\"error\":\"not found\"}`)\n", j.responseCheck("users 0", "/api/users", &rows[2]))
+	require.Empty(t, j.responseCheck("users 0", "/api/users", &proxy.BodyRecords{Method: "GET", StatusCode: 200, ResponseBody: "ok"}))
+	require.Empty(t, j.responseCheck("users 0", "/api/users", &proxy.BodyRecords{Method: "HEAD", StatusCode: 200, ResponseBody: "{}"}))
+
+	table := j.genGetRun("/api/users", rows[1:])
+	require.Contains(t, table, "checkResponse: func(t *testing.T, resp *http.Response) {\n_, body := decodeResp[models.UserResp](t, resp)\n")
+	require.Contains(t, table, "if tc.checkResponse != nil {\ntc.checkResponse(t, resp)\n}")
+}
+
+func TestBodyMatcher(t *testing.T) {
+	m, err := newBodyMatcher(AssertRules{Ignore: []string{"etag"}, TypeOnly: []string{"$.meta.total", "tags"}})
+	require.NoError(t, err)
+	tests := []struct {
+		body     string
+		expected string
+	}{
+		{`{"name":"john","age":30,"admin":false}`, `{"admin":false,"age":30,"name":"john"}`},
+		{`{"id":42,"user_id":"u_1","orderId":7,"created_at":"x","updatedAt":null}`, `{"created_at":"<string>","id":"<number>","orderId":"<number>","updatedAt":"<any>","user_id":"<string>"}`},
+		{`{"name":null,"parent_id":null}`, `{"name":null,"parent_id":"<any>"}`},
+		{`{"seen":"2026-01-01T10:15:00Z","ref":"3f2b8c1e-9a4d-4e2f-8b1a-2c3d4e5f6a7b","token":"${TESTGEN_TOKEN}"}`, `{"ref":"<string>","seen":"<string>","token":"<string>"}`},
+		{`[{"id":1,"name":"a"},{"id":2,"name":"b"}]`, `[{"id":"<number>","name":"a"},{"id":"<number>","name":"b"}]`},
+		{`{"etag":"abc","meta":{"total":3,"etag":{"v":1}},"total":3,"tags":["a"]}`, `{"etag":"<any>","meta":{"etag":"<any>","total":"<number>"},"tags":"<array>","total":3}`},
+		{`{"html":"<b>x</b>"}`, `{"html":"<b>x</b>"}`},
+	}
+	for _, tt := range tests {
+		got, ok := m.expected(tt.body)
+		require.True(t, ok)
+		require.Equal(t, tt.expected, got)
+	}
+	_, ok := m.expected("not json")
+	require.False(t, ok)
+
+	_, err = newBodyMatcher(AssertRules{Ignore: []string{"$.meta..id"}})
+	require.Error(t, err)
+	_, err = newBodyMatcher(AssertRules{TypeOnly: []string{" "}})
+	require.Error(t, err)
+
+	require.Equal(t, "`{\"a\":1}`", bodyLiteral(`{"a":1}`))
+	require.Equal(t, `"{\"a\":\"`+"`"+`\"}"`, bodyLiteral(`{"a":"`+"`"+`"}`))
+}
+
+func TestGoldenFiles(t *testing.T) {
+	t.Chdir(t.TempDir())
+	require.Equal(t, "post_users_0", goldenSlug("POST users 0"))
+	require.Equal(t, "get_step_2", goldenSlug("GET step 2"))
+
+	j := &JsonFile{Golden: true, testName: "users"}
+	row := &proxy.BodyRecords{Method: "POST", StatusCode: 201, ResponseBody: `{"id":42,"name":"john","tags":["a"]}`}
+	require.Equal(t, "_, body := decodeResp[any](t, resp)\nrequireGolden(t, body, \"users/post_users_0\")\n", j.responseCheck("users 0", "/api/users", row))
+	require.NoError(t, j.writeGoldens())
+
+	got, err := os.ReadFile(filepath.Join(testFileDir, goldenDir, "users", "post_users_0.golden.json"))
+	require.NoError(t, err)
+	require.Equal(t, "{\n  \"id\": \"<number>\",\n  \"name\": \"john\",\n  \"tags\": [\n    \"a\"\n  ]\n}\n", string(got))
+
+	// golden files accepted with go test -update survive a regeneration
+	file := filepath.Join(testFileDir, goldenDir, "users", "post_users_0.golden.json")
+	require.NoError(t, os.WriteFile(file, []byte("{\"id\": 1}\n"), 0o644))
+	require.NoError(t, j.writeGoldens())
+	got, err = os.ReadFile(file)
+	require.NoError(t, err)
+	require.Equal(t, "{\"id\": 1}\n", string(got))
+
+	j.UpdateGolden = true
+	require.NoError(t, j.writeGoldens())
+	got, err = os.ReadFile(file)
+	require.NoError(t, err)
+	require.Contains(t, string(got), "\"name\": \"john\"")
+}
+
+func TestFormatFile(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/app\n\ngo 1.25\n"), 0o644))
+	j := &JsonFile{BaseDir: dir, routes: map[string]*Route{
+		"/api/users": {Default: newModel("models.User")},
+		"/api/teams": {Methods: []*MethodModels{{Response: &Model{Folder: "./internal/api", Struct: "Team", Name: "api.Team"}}}},
+	}}
+	imports := j.importPaths()
+	require.Equal(t, "example.com/app/models", imports["models"])
+	require.Equal(t, "example.com/app/internal/api", imports["api"])
+
+	src := `package gentests
+
+import (
+	"fmt"
+	"testing"
+)
+
+func testusers(t *testing.T){
+app := setup()
+payload := models.User{Name: os.Getenv("NAME"), Born: time.Now()}
+var team api.Team
+resp := makeReq(t, app, http.MethodPost, "/api/users", payload)
+require.Equal(t, http.StatusOK, resp.StatusCode)
+_ = team
+url := resp.Request.URL
+_ = url.Path
+}
+`
+	got, err := formatFile([]byte(src), imports)
+	require.NoError(t, err)
+	require.Equal(t, `package gentests
+
+import (
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"example.com/app/internal/api"
+	"example.com/app/models"
+	"github.com/stretchr/testify/require"
+)
+
+func testusers(t *testing.T) {
+	app := setup()
+	payload := models.User{Name: os.Getenv("NAME"), Born: time.Now()}
+	var team api.Team
+	resp := makeReq(t, app, http.MethodPost, "/api/users", payload)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	_ = team
+	url := resp.Request.URL
+	_ = url.Path
+}
+`, string(got))
+
+	again, err := formatFile(got, imports)
+	require.NoError(t, err)
+	require.Equal(t, string(got), string(again))
+
+	_, err = formatFile([]byte("package gentests\nfunc {"), imports)
+	require.Error(t, err)
+}
+
+func TestCheckFiles(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"go.mod":               "module example.com/app\n\ngo 1.25\n",
+		"models/models.go":     "package models\n\ntype Contact struct{ Addr string }\n\ntype User struct {\n\tName  string\n\tEmail Contact\n}\n",
+		"gentest/testutils.go": "package gentests\n",
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+	}
+	t.Chdir(dir)
+
+	rows := []proxy.BodyRecords{{Method: "POST", Path: "/api/users"}, {Method: "POST", Path: "/api/users"}}
+	j := &JsonFile{Filename: "users.json", testName: "users", rows: map[*proxy.BodyRecords]int{&rows[0]: 0, &rows[1]: 1}}
+	j.addOrigin("users 0", "/api/users", &rows[0])
+	j.addOrigin("users 1", "/api/users", &rows[1])
+	j.aliasOrigin("Create users", "users 1")
+
+	src := `package gentests
+
+import (
+	"testing"
+
+	"example.com/app/models"
+)
+
+func testusers(t *testing.T) {
+	t.Run("Create users", func(t *testing.T) {
+		_ = models.User{Name: "john", Email: models.Contact("j@x.io")}
+	})
+	testCases := []struct {
+		name    string
+		payload models.User
+	}{
+		{name: "users 0", payload: models.User{Name: 1}},
+	}
+	_ = testCases
+}
+`
+	err := j.checkFiles(map[string][]byte{"users_test.go": []byte(src)})
+	var diags Diagnostics
+	require.ErrorAs(t, err, &diags)
+	require.Len(t, diags, 2)
+	require.Equal(t, filepath.Join("gentest", "users_test.go"), diags[0].Pos.Filename)
+	require.Equal(t, 11, diags[0].Pos.Line)
+	require.Contains(t, diags[0].Msg, "(recorded POST /api/users in users.json: /api/users bodyRecords[1], field models.User.Email)")
+	require.Equal(t, 17, diags[1].Pos.Line)
+	require.Contains(t, diags[1].Msg, "(recorded POST /api/users in users.json: /api/users bodyRecords[0], field models.User.Name)")
+
+	require.NoError(t, j.checkFiles(map[string][]byte{"users_test.go": []byte("package gentests\n")}))
+}
+
+func TestMergeKept(t *testing.T) {
+	old := `package gentests
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testusers(t *testing.T) {
+	app := setup()
+
+	t.Run("Create users", func(t *testing.T) {
+		resp := makeReq(t, app, http.MethodPost, "/api/users", nil)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+		//testgen:keep
+		require.True(t, strings.HasPrefix(resp.Header.Get("Location"), "/api/users/"))
+	})
+
+	//testgen:keep
+	t.Run("Create duplicate", func(t *testing.T) {
+		resp := makeReq(t, app, http.MethodPost, "/api/users", nil)
+		require.Equal(t, http.StatusConflict, resp.StatusCode)
+	})
+
+	t.Run("Delete users", func(t *testing.T) {
+		t.Log("written by hand without a marker")
+	})
+
+	t.Run("Get users", func(t *testing.T) {
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				resp := makeReq(t, app, http.MethodGet, tc.path, nil)
+				// testgen:keep needs no space
+				//testgen:keep
+				require.NotEmpty(t, resp.Header.Get("ETag"))
+			})
+		}
+	})
+}
+
+// testorders is gone from the recordings.
+func testorders(t *testing.T) {
+	t.Run("Get orders", func(t *testing.T) {})
+}
+
+// testitems was edited by hand.
+//
+//testgen:keep
+func testitems(t *testing.T) {
+	t.Log("mine")
+}
+
+func TestUsers(t *testing.T) {
+	testusers(t)
+}
+`
+	generated := `package gentests
+
+import (
+"net/http"
+"testing"
+"github.com/stretchr/testify/require"
+)
+
+func testusers(t *testing.T){
+app := setup()
+
+t.Run("Create users", func(t *testing.T) {
+resp := makeReq(t, app, http.MethodPost, "/api/users", nil)
+require.Equal(t, http.StatusCreated, resp.StatusCode)
+})
+
+t.Run("Get users", func(t *testing.T) {
+for _, tc := range testCases {
+t.Run(tc.name, func(t *testing.T) {
+resp := makeReq(t, app, http.MethodGet, tc.path, nil)
+require.Equal(t, tc.expectedStatus, resp.StatusCode)
+})
+}
+})
+
+}
+
+func testitems(t *testing.T){
+t.Log("generated")
+}
+
+`
+	merged, err := mergeKept([]byte(old), []byte(generated))
+	require.NoError(t, err)
+	src := string(merged)
+	_, err = parser.ParseFile(token.NewFileSet(), "users_test.go", merged, 0)
+	require.NoError(t, err, src)
+
+	require.Contains(t, src, "\"strings\"\n)")
+	require.Contains(t, src, "require.Equal(t, http.StatusCreated, resp.StatusCode)\n//testgen:keep\n\t\trequire.True(t, strings.HasPrefix(")
+	require.Contains(t, src, "resp := makeReq(t, app, http.MethodGet, tc.path, nil)\n//testgen:keep\n\t\t\t\trequire.NotEmpty(")
+	require.Less(t, strings.Index(src, "require.True(t, strings"), strings.Index(src, `t.Run("Create duplicate"`))
+	require.Less(t, strings.Index(src, `t.Run("Create duplicate"`), strings.Index(src, `t.Run("Get users"`))
+	require.Contains(t, src, "func TestUsers(t *testing.T) {\n\ttestusers(t)\n}")
+	require.Contains(t, src, "t.Log(\"mine\")")
+	require.NotContains(t, src, "t.Log(\"generated\")")
+	require.NotContains(t, src, "testorders")
+	require.Equal(t, 1, strings.Count(src, "func testusers("))
+
+	require.NotContains(t, src, "Delete users")
+
+	// unmarked subtests that are not generated are reported when dropped
+	k, err := readKept([]byte(old))
+	require.NoError(t, err)
+	f, err := parser.ParseFile(token.NewFileSet(), "", generated, parser.SkipObjectResolution)
+	require.NoError(t, err)
+	funcs := make(map[string]*ast.FuncDecl)
+	for _, decl := range f.Decls {
+		if fd, ok := decl.(*ast.FuncDecl); ok {
+			funcs[fd.Name.Name] = fd
+		}
+	}
+	text := func(from, to token.Pos) string { return generated[from-f.FileStart : to-f.FileStart] }
+	require.Equal(t, []subtest{
+		{fn: "testorders", name: `"Get orders"`},
+		{fn: "testusers", name: `"Delete users"`},
+	}, k.dropped(funcs, text))
+
+	again, err := mergeKept(merged, []byte(generated))
+	require.NoError(t, err)
+	require.Equal(t, src, string(again))
+
+	_, err = mergeKept([]byte("package gentests\nfunc {"), []byte(generated))
+	require.Error(t, err)
+}
+
 func TestFilterByMethod(t *testing.T) {
 	rows := []struct {
 		Method string
@@ -210,3 +987,501 @@ func TestGetFuncName(t *testing.T) {
 		require.Equal(t, tt.expected, result)
 	}
 }
+
+func TestPathExpr(t *testing.T) {
+	j := &JsonFile{}
+	require.Equal(t, `"/api/users"`, j.pathExpr("/api/users", proxy.BodyRecords{}))
+
+	row := proxy.BodyRecords{
+		RawQuery: "sort=name&page=2&tag=a&tag=b",
+		Query:    url.Values{"sort": {"name"}, "page": {"2"}, "tag": {"a", "b"}},
+	}
+	require.Equal(t,
+		`"/api/users?" + url.Values{"page": {"2",},"sort": {"name",},"tag": {"a","b",},}.Encode()`,
+		j.pathExpr("/api/users", row))
+}
+
+func TestGenRun(t *testing.T) {
+	j := &JsonFile{}
+	rows := []proxy.BodyRecords{
+		{Method: "GET", StatusCode: 200},
+		{Method: "GET", StatusCode: 299, Query: url.Values{"page": {"2"}}},
+	}
+
+	single := j.genGetRun("/api/users", rows[:1])
+	require.Contains(t, single, `resp := makeReq(t, app, http.MethodGet, "/api/users", nil)`)
+	require.Contains(t, single, `require.Equal(t, http.StatusOK, resp.StatusCode)`)
+
+	table := j.genGetRun("/api/users", rows)
+	require.Contains(t, table, `{name: "users 1", path: "/api/users?" + url.Values{"page": {"2",},}.Encode(), expectedStatus: 299},`)
+	require.Contains(t, table, `resp := makeReq(t, app, http.MethodGet, tc.path, nil)`)
+
+	require.Empty(t, j.genDelRun("/api/users", nil))
+	require.Contains(t, j.genPostRun("/api/users", []proxy.BodyRecords{{Method: "POST", Body: "{}"}}), "payload := UsersCreateRequest{\n}")
+	require.Empty(t, j.genPostRun("/api/teams", []proxy.BodyRecords{{Method: "POST", Body: "name=jane"}}))
+}
+
+func TestInferBodies(t *testing.T) {
+	j := &JsonFile{}
+	rows := []proxy.BodyRecords{
+		{Method: "POST", Body: `{"name":"john","age":3,"score":1.5,"address":{"city":"x"},"tags":[{"id":1}],"meta":"a","nick":null}`},
+		{Method: "POST", Body: `{"name":"jane","score":2,"address":{"city":"y","zip":"1"},"tags":[],"meta":1}`},
+		{Method: "POST", Body: `[1,2]`},
+		{Method: "PUT", Body: `{"other":true}`},
+	}
+	j.inferBodies("/api/users", "POST", rows)
+	it := j.inferred["/api/users POST"]
+	require.Equal(t, "UsersCreateRequest", it.name)
+
+	decls := make(map[string]string)
+	it.shape.writeDecls(it.name, decls)
+	require.Equal(t, "type UsersCreateRequest struct {\n"+
+		"Address UsersCreateRequestAddress `json:\"address\"`\n"+
+		"Age *int64 `json:\"age,omitempty\"`\n"+
+		"Meta any `json:\"meta\"`\n"+
+		"Name string `json:\"name\"`\n"+
+		"Nick any `json:\"nick,omitempty\"`\n"+
+		"Score float64 `json:\"score\"`\n"+
+		"Tags []UsersCreateRequestTags `json:\"tags\"`\n"+
+		"}", decls["UsersCreateRequest"])
+	require.Equal(t, "type UsersCreateRequestAddress struct {\nCity string `json:\"city\"`\nZip *string `json:\"zip,omitempty\"`\n}", decls["UsersCreateRequestAddress"])
+	require.Equal(t, "type UsersCreateRequestTags struct {\nID int64 `json:\"id\"`\n}", decls["UsersCreateRequestTags"])
+
+	name, lit, err := j.payload("/api/users", &rows[0])
+	require.NoError(t, err)
+	require.Equal(t, "UsersCreateRequest", name)
+	require.Equal(t, "UsersCreateRequest{\n"+
+		"Address: UsersCreateRequestAddress{\nCity: \"x\",\n},\n"+
+		"Age: Ptr[int64](3),\n"+
+		"Meta: \"a\",\n"+
+		"Name: \"john\",\n"+
+		"Score: 1.5,\n"+
+		"Tags: []UsersCreateRequestTags{UsersCreateRequestTags{\nID: 1,\n}},\n"+
+		"}", lit)
+	_, _, err = j.payload("/api/users", &rows[2])
+	require.ErrorIs(t, err, errNotJSON)
+	_, _, err = j.payload("/api/users", &rows[3])
+	require.ErrorIs(t, err, errNoModel)
+}
+
+func TestTypesSource(t *testing.T) {
+	t.Chdir(t.TempDir())
+	require.NoError(t, os.MkdirAll(testFileDir, 0o755))
+	existing := "// Code generated by testgen. DO NOT EDIT.\n\npackage gentests\n\ntype TeamsCreateRequest struct {\n\tName string `json:\"name\"`\n}\n"
+	require.NoError(t, os.WriteFile(filepath.Join(testFileDir, typesFileName), []byte(existing), 0o644))
+
+	j := &JsonFile{}
+	j.inferBodies("/api/users", "POST", []proxy.BodyRecords{{Method: "POST", Body: `{"name":"john"}`}})
+	j.inferBodies("/api/users", "PUT", []proxy.BodyRecords{{Method: "PUT", Body: `{"name":"john"}`}})
+	_, _, err := j.payload("/api/users", &proxy.BodyRecords{Method: "POST", Body: `{"name":"john"}`})
+	require.NoError(t, err)
+	src, err := j.typesSource()
+	require.NoError(t, err)
+	require.Contains(t, string(src), "type TeamsCreateRequest struct {\n\tName string `json:\"name\"`\n}")
+	require.Contains(t, string(src), "type UsersCreateRequest struct {\n\tName string `json:\"name\"`\n}")
+	// the PUT type is never used by a test
+	require.NotContains(t, string(src), "UsersUpdateRequest")
+}
+
+func TestTypesSource_SharedName(t *testing.T) {
+	t.Chdir(t.TempDir())
+	require.NoError(t, os.MkdirAll(testFileDir, 0o755))
+	existing := "// Code generated by testgen. DO NOT EDIT.\n\npackage gentests\n\n// UsersCreateRequest is inferred from POST /v1/users.\ntype UsersCreateRequest struct {\n\tName string `json:\"name\"`\n}\n"
+	require.NoError(t, os.WriteFile(filepath.Join(testFileDir, typesFileName), []byte(existing), 0o644))
+
+	// another endpoint with the same function name keeps the existing type
+	j := &JsonFile{}
+	j.inferBodies("/v2/users", "POST", []proxy.BodyRecords{{Method: "POST", Body: `{"email":"a@b.c"}`}})
+	name, _, err := j.payload("/v2/users", &proxy.BodyRecords{Method: "POST", Body: `{"email":"a@b.c"}`})
+	require.NoError(t, err)
+	require.Equal(t, "UsersCreateRequest2", name)
+	src, err := j.typesSource()
+	require.NoError(t, err)
+	require.Contains(t, string(src), "// UsersCreateRequest is inferred from POST /v1/users.\ntype UsersCreateRequest struct {\n\tName string `json:\"name\"`\n}")
+	require.Contains(t, string(src), "// UsersCreateRequest2 is inferred from POST /v2/users.\ntype UsersCreateRequest2 struct {\n\tEmail string `json:\"email\"`\n}")
+
+	// the endpoint that owns the name rewrites it
+	j = &JsonFile{}
+	j.inferBodies("/v1/users", "POST", []proxy.BodyRecords{{Method: "POST", Body: `{"age":3}`}})
+	name, _, err = j.payload("/v1/users", &proxy.BodyRecords{Method: "POST", Body: `{"age":3}`})
+	require.NoError(t, err)
+	require.Equal(t, "UsersCreateRequest", name)
+	src, err = j.typesSource()
+	require.NoError(t, err)
+	require.Contains(t, string(src), "type UsersCreateRequest struct {\n\tAge int64 `json:\"age\"`\n}")
+}
+
+func TestDetectFramework(t *testing.T) {
+	tests := []struct {
+		name     string
+		gomod    string
+		expected string
+	}{
+		{"fiber v3", "module a\n\nrequire github.com/gofiber/fiber/v3 v3.0.0\n", "fiber3"},
+		{"fiber v2", "module a\n\nrequire (\n\tgithub.com/gofiber/fiber/v2 v2.52.0\n\tgithub.com/stretchr/testify v1.11.1\n)\n", "fiber2"},
+		{"gin", "module a\n\nrequire github.com/gin-gonic/gin v1.10.0\n", "gin"},
+		{"echo", "module a\n\nrequire github.com/labstack/echo/v4 v4.12.0\n", "echo"},
+		{"chi", "module a\n\nrequire github.com/go-chi/chi/v5 v5.1.0\n", "chi"},
+		{"stdlib", "module a\n\ngo 1.25\n", "nethttp"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "go.mod"), []byte(tt.gomod), 0o644))
+			got, err := DetectFramework(dir)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, got)
+		})
+	}
+	_, err := DetectFramework(t.TempDir())
+	require.Error(t, err)
+}
+
+func TestHarnessFiles(t *testing.T) {
+	tests := []struct {
+		framework string
+		imports   string
+		serve     string
+	}{
+		{"nethttp", "", "app.ServeHTTP(rec, req)"},
+		{"chi", `"github.com/go-chi/chi/v5"`, "app.ServeHTTP(rec, req)"},
+		{"gin", `"github.com/gin-gonic/gin"`, "app.ServeHTTP(rec, req)"},
+		{"echo", `"github.com/labstack/echo/v4"`, "app.ServeHTTP(rec, req)"},
+		{"fiber2", `"github.com/gofiber/fiber/v2"`, "resp, err := app.Test(req, -1)"},
+		{"fiber3", `"github.com/gofiber/fiber/v3"`, "resp, err := app.Test(req)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.framework, func(t *testing.T) {
+			h, err := (&JsonFile{Framework: tt.framework}).harness()
+			require.NoError(t, err)
+			main, err := render(mainTest, h)
+			require.NoError(t, err)
+			helpers, err := render(helperFile, h)
+			require.NoError(t, err)
+			for name, src := range map[string]string{"main_test.go": main, helpersFileName: helpers} {
+				_, err := parser.ParseFile(token.NewFileSet(), name, src, 0)
+				require.NoError(t, err, src)
+				require.Contains(t, src, tt.imports)
+			}
+			require.Contains(t, main, "func setup() "+h.AppType+" {")
+			require.Contains(t, helpers, "app "+h.AppType+", method")
+			require.Contains(t, helpers, tt.serve)
+		})
+	}
+	_, err := (&JsonFile{Framework: "rails"}).harness()
+	require.Error(t, err)
+}
+
+func TestExternalHarnessFiles(t *testing.T) {
+	t.Chdir(t.TempDir())
+	j := &JsonFile{External: true, Framework: "rails"}
+	require.NoError(t, j.detectFiles())
+
+	main, err := os.ReadFile(filepath.Join(testFileDir, "main_test.go"))
+	require.NoError(t, err)
+	helpers, err := os.ReadFile(filepath.Join(testFileDir, helpersFileName))
+	require.NoError(t, err)
+	for name, src := range map[string][]byte{"main_test.go": main, helpersFileName: helpers} {
+		_, err := parser.ParseFile(token.NewFileSet(), name, src, 0)
+		require.NoError(t, err, string(src))
+	}
+	require.Contains(t, string(main), "func setup() *http.Client {")
+	require.Contains(t, string(helpers), "app *http.Client, method")
+	for _, env := range []string{"TESTGEN_BASE_URL", "TESTGEN_TIMEOUT", "TESTGEN_CA_FILE", "TESTGEN_INSECURE_SKIP_VERIFY"} {
+		require.Contains(t, string(helpers), env)
+	}
+}
+
+func TestExistingHarness(t *testing.T) {
+	t.Chdir(t.TempDir())
+	require.NoError(t, os.MkdirAll(testFileDir, 0o755))
+	main := "package gentests\n\nfunc setup() *gin.Engine { return nil }\n"
+	old := `package gentests
+
+// makeReq was written by an older testgen.
+func makeReq(t *testing.T, app *gin.Engine, method, path string, body any) *http.Response {
+	return nil
+}
+
+func Ptr[T any](v T) *T { return &v }
+`
+	require.NoError(t, os.WriteFile(filepath.Join(testFileDir, "main_test.go"), []byte(main), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(testFileDir, "testutils.go"), []byte(old), 0o644))
+
+	j := &JsonFile{Framework: "gin"}
+	for range 2 {
+		require.NoError(t, j.detectFiles())
+		src, err := os.ReadFile(filepath.Join(testFileDir, "main_test.go"))
+		require.NoError(t, err)
+		require.Equal(t, main, string(src))
+
+		helpers, err := os.ReadFile(filepath.Join(testFileDir, helpersFileName))
+		require.NoError(t, err)
+		require.True(t, strings.HasPrefix(string(helpers), "// Code generated by testgen. DO NOT EDIT."))
+		_, err = parser.ParseFile(token.NewFileSet(), helpersFileName, helpers, 0)
+		require.NoError(t, err)
+		require.Contains(t, string(helpers), "func requireJSON(")
+		require.Contains(t, string(helpers), "func requireGolden(")
+		require.Contains(t, string(helpers), "func decodeResp[")
+		require.NotContains(t, string(helpers), "makeReq")
+		require.NotContains(t, string(helpers), "func Ptr")
+		// the gin import was only used by makeReq
+		require.NotContains(t, string(helpers), "gin-gonic")
+	}
+
+	require.Equal(t, "*gin.Engine", setupType(filepath.Join(testFileDir, "main_test.go")))
+	require.Equal(t, "", setupType(filepath.Join(testFileDir, "testutils.go")))
+}
+
+func TestHeaderExpr(t *testing.T) {
+	j := &JsonFile{}
+	require.Equal(t, "", j.headerExpr(nil))
+	require.Equal(t, "", j.headerExpr(http.Header{"User-Agent": {"curl"}, "Sec-Fetch-Mode": {"cors"}}))
+	require.Equal(t,
+		`http.Header{"Accept": {"application/json",},"X-Tenant": {"a","b",},}`,
+		j.headerExpr(http.Header{"X-Tenant": {"a", "b"}, "Accept": {"application/json"}, "Content-Length": {"2"}}))
+}
+
+func TestGenRunHeaders(t *testing.T) {
+	j := &JsonFile{}
+	rows := []proxy.BodyRecords{
+		{Method: "GET", StatusCode: 200, Header: http.Header{"Accept": {"application/json"}}, ResponseHeader: http.Header{"Content-Type": {"application/json"}}},
+		{Method: "GET", StatusCode: 200},
+	}
+
+	single := j.genGetRun("/api/users", rows[:1])
+	require.Contains(t, single, `resp := makeReq(t, app, http.MethodGet, "/api/users", nil, http.Header{"Accept": {"application/json",},})`)
+	require.Contains(t, single, `require.Equal(t, "application/json", resp.Header.Get("Content-Type"))`)
+
+	table := j.genGetRun("/api/users", rows)
+	require.Contains(t, table, `headers: http.Header{"Accept": {"application/json",},}, expectedStatus: http.StatusOK, expectedHeaders: map[string]string{"Content-Type": "application/json",}},`)
+	require.Contains(t, table, `{name: "users 1", path: "/api/users", expectedStatus: http.StatusOK},`)
+	require.Contains(t, table, `resp := makeReq(t, app, http.MethodGet, tc.path, nil, tc.headers)`)
+	require.Contains(t, table, `require.Equal(t, value, resp.Header.Get(name))`)
+
+	// Location holds the ID of the created resource, it is only required
+	rows = []proxy.BodyRecords{
+		{Method: "POST", StatusCode: 201, ResponseHeader: http.Header{"Location": {"http://localhost:8080/api/users/7"}}},
+		{Method: "POST", StatusCode: 201, ResponseHeader: http.Header{"Location": {"/api/users/8"}, "Content-Type": {"application/json"}}},
+	}
+	single = j.genDelRun("/api/users", rows[:1])
+	require.Contains(t, single, `require.NotEmpty(t, resp.Header.Get("Location"))`)
+	require.NotContains(t, single, "/api/users/7")
+	table = j.genDelRun("/api/users", rows)
+	require.Contains(t, table, `expectedHeaders: map[string]string{"Content-Type": "application/json","Location": "",}},`)
+	require.Contains(t, table, "if value == \"\" {\nrequire.NotEmpty(t, resp.Header.Get(name), name)\ncontinue\n}\n")
+	require.NotContains(t, table, "/api/users/8")
+}
+
+func TestStringExpr(t *testing.T) {
+	j := &JsonFile{}
+	require.Equal(t, `"plain"`, j.stringExpr("plain"))
+	require.Equal(t, `os.Getenv("TESTGEN_AUTHORIZATION")`, j.stringExpr("${TESTGEN_AUTHORIZATION}"))
+}
+
+func TestSplitScenarios(t *testing.T) {
+	start := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	entry := func(seq int64, client string, after time.Duration) proxy.TimelineEntry {
+		return proxy.TimelineEntry{BodyRecords: proxy.BodyRecords{Seq: seq, Client: client, Timestamp: start.Add(after)}}
+	}
+	timeline := []proxy.TimelineEntry{
+		entry(1, "10.0.0.1:5000", 0),
+		entry(2, "10.0.0.2:6000", time.Second),
+		entry(3, "10.0.0.1:5001", 2*time.Second),
+		entry(4, "10.0.0.1:5002", 5*time.Minute),
+	}
+
+	scenarios := splitScenarios(timeline, 0)
+	require.Len(t, scenarios, 2)
+	require.Equal(t, "10.0.0.1", scenarios[0].client)
+	require.Len(t, scenarios[0].steps, 3)
+
+	scenarios = splitScenarios(timeline, time.Minute)
+	require.Len(t, scenarios, 3)
+	require.Len(t, scenarios[0].steps, 2)
+	require.Equal(t, "10.0.0.2", scenarios[1].client)
+	require.Equal(t, int64(4), scenarios[2].steps[0].Seq)
+
+	// a local recording is a single client, the default gap still separates
+	// its flows
+	local := []proxy.TimelineEntry{
+		entry(1, "127.0.0.1:5000", 0),
+		entry(2, "127.0.0.1:5001", 10*time.Second),
+		entry(3, "127.0.0.1:5002", time.Minute),
+	}
+	scenarios = splitScenarios(local, DefaultScenarioGap)
+	require.Len(t, scenarios, 2)
+	require.Len(t, scenarios[0].steps, 2)
+	require.Equal(t, int64(3), scenarios[1].steps[0].Seq)
+}
+
+func TestGenScenarioFunction(t *testing.T) {
+	j := &JsonFile{}
+	sc := scenario{client: "10.0.0.1", steps: []proxy.TimelineEntry{
+		{Endpoint: "/api/orders", BodyRecords: proxy.BodyRecords{Method: "POST", Path: "/api/orders", RawPath: "/api/orders", Body: `{"item":"book"}`, StatusCode: 201}},
+		{Endpoint: "/api/orders", BodyRecords: proxy.BodyRecords{Method: "GET", Path: "/api/orders/:id", RawPath: "/api/orders/12", StatusCode: 200}},
+	}}
+
+	out := j.genScenarioFunction(1, sc)
+	require.Contains(t, out, "// testScenario1 replays the requests made by 10.0.0.1: POST /api/orders -> GET /api/orders/:id\n")
+	require.Contains(t, out, `payload := json.RawMessage("{\"item\":\"book\"}")`)
+	require.Contains(t, out, `resp := makeReq(t, app, http.MethodPost, "/api/orders", payload)`)
+	require.Contains(t, out, `if !t.Run("2 GET /api/orders/:id", func(t *testing.T) {`)
+	require.Contains(t, out, `resp := makeReq(t, app, http.MethodGet, "/api/orders/12", nil)`)
+	require.Less(t, strings.Index(out, "1 POST"), strings.Index(out, "2 GET"))
+}
+
+func TestExportName(t *testing.T) {
+	tests := map[string]string{
+		"id":         "ID",
+		"user_id":    "UserID",
+		"userId":     "UserID",
+		"name":       "Name",
+		"2fa":        "F2fa",
+		"user-email": "UserEmail",
+	}
+	for input, expected := range tests {
+		require.Equal(t, expected, exportName(input), input)
+	}
+}
+
+func TestIDLeaves(t *testing.T) {
+	leaves := idLeaves(`{"name":"john","count":3,"data":{"userId":"u_1","id":42},"tags":["a"]}`)
+	fields := make([]string, 0)
+	for _, l := range leaves {
+		fields = append(fields, strings.Join(l.field, "."))
+	}
+	require.Equal(t, []string{"data.id", "data.userId", "name"}, fields)
+	require.Equal(t, "42", leaves[0].value)
+	require.False(t, leaves[0].str)
+	require.Nil(t, idLeaves(`[{"id":1}]`))
+}
+
+func TestPlanChain(t *testing.T) {
+	rows := []proxy.BodyRecords{
+		{Method: "POST", ResponseBody: `{"id":7,"count":1}`},
+		{Method: "POST", ResponseBody: `{"id":8}`},
+		{Method: "GET", RawPath: "/api/users/7", PathParams: []proxy.PathParam{{Name: "id", Value: "7"}}},
+		{Method: "DELETE", RawPath: "/api/users/7"},
+		{Method: "GET", RawPath: "/api/users/1"},
+	}
+	ptrs := []*proxy.BodyRecords{&rows[0], &rows[1], &rows[2], &rows[3], &rows[4]}
+	plan := planChain(ptrs, []string{"/api/users", "/api/users", "/api/users", "/api/users", "/api/users"})
+
+	require.Len(t, plan.vars, 1)
+	require.Equal(t, "usersID", plan.vars[0].name)
+	require.Len(t, plan.captures[&rows[0]], 1)
+	require.Empty(t, plan.captures[&rows[1]])
+	require.Equal(t, "usersID", plan.subst[&rows[2]]["7"])
+	require.Equal(t, "usersID", plan.subst[&rows[3]]["7"])
+	require.Empty(t, plan.subst[&rows[4]])
+
+	j := &JsonFile{chain: plan}
+	require.Equal(t, "var usersID string\n\n", plan.declarations())
+	require.Equal(t, `"/api/users/" + usersID`, j.rowPath("/api/users", &rows[2]))
+	require.Equal(t, `"/api/users/1"`, j.rowPath("/api/users", &rows[4]))
+	require.Equal(t, "created, _ := decodeResp[struct {\nID json.Number `json:\"id\"`\n}](t, resp)\nusersID = created.ID.String()\n", j.captureCode(&rows[0]))
+
+	// a POST in the table of its source keeps the recorded path
+	items := []proxy.BodyRecords{
+		{Method: "POST", RawPath: "/items", ResponseBody: `{"id":"itm_abc123"}`},
+		{Method: "POST", RawPath: "/items/itm_abc123"},
+		{Method: "GET", RawPath: "/items/itm_abc123"},
+	}
+	plan = planChain([]*proxy.BodyRecords{&items[0], &items[1], &items[2]}, []string{"/items", "/items", "/items"})
+	require.Empty(t, plan.subst[&items[1]])
+	require.Equal(t, "itemsID", plan.subst[&items[2]]["itm_abc123"])
+}
+
+func TestPlanChainBody(t *testing.T) {
+	rows := []proxy.BodyRecords{
+		{Method: "POST", ResponseBody: `{"id":7,"uuid":"u_7"}`},
+		{Method: "POST", Body: `{"parent_id":7}`},
+		{Method: "POST", Body: `{"user":{"id":7,"uuid":"u_7"},"qty":7,"note":"u_7"}`},
+		{Method: "PUT", Body: `{"userUuid":"u_7"}`},
+	}
+	ptrs := []*proxy.BodyRecords{&rows[0], &rows[1], &rows[2], &rows[3]}
+	plan := planChain(ptrs, []string{"/api/users", "/api/users", "/api/orders", "/api/users"})
+
+	// the second user is in the table of the first one
+	require.Empty(t, plan.fields[&rows[1]])
+	require.Equal(t, map[string]string{"user.id": "usersID", "user.uuid": "usersUuid"}, plan.fields[&rows[2]])
+	require.Equal(t, map[string]string{"userUuid": "usersUuid"}, plan.fields[&rows[3]])
+	require.Len(t, plan.captures[&rows[0]], 2)
+
+	j := &JsonFile{chain: plan}
+	j.inferBodies("/api/orders", "POST", rows[2:3])
+	_, lit, err := j.payload("/api/orders", &rows[2])
+	require.NoError(t, err)
+	require.Equal(t, "OrdersCreateRequest{\n"+
+		"Note: \"u_7\",\n"+
+		"Qty: 7,\n"+
+		"User: OrdersCreateRequestUser{\nID: parseID(t, usersID),\nUuid: usersUuid,\n},\n"+
+		"}", lit)
+}
+
+func TestGenRunChain(t *testing.T) {
+	rows := []proxy.BodyRecords{
+		{Method: "POST", StatusCode: 201, ResponseBody: `{"data":{"id":"u_123456"}}`},
+		{Method: "GET", StatusCode: 200, RawPath: "/api/users/u_123456", PathParams: []proxy.PathParam{{Name: "id", Value: "u_123456"}}},
+	}
+	j := &JsonFile{}
+	j.chain = planChain([]*proxy.BodyRecords{&rows[0], &rows[1]}, []string{"/api/users", "/api/users"})
+
+	post := j.genRun("Create", "http.MethodPost", "/api/users", "", []testCase{j.newTestCase("users 0", "/api/users", &rows[0])})
+	require.Contains(t, post, "created, body := decodeResp[struct {\nData struct {\nID string `json:\"id\"`\n} `json:\"data\"`\n}](t, resp)\nusersID = created.Data.ID\nrequireJSON(t, body, `{\"data\":{\"id\":\"<string>\"}}`)\n")
+
+	get := j.genGetRun("/api/users", rows[1:])
+	require.Contains(t, get, `resp := makeReq(t, app, http.MethodGet, "/api/users/" + usersID, nil)`)
+}
+
+func TestRowPath(t *testing.T) {
+	j := &JsonFile{}
+	tests := []struct {
+		name     string
+		row      proxy.BodyRecords
+		expected string
+	}{
+		{"no path recorded", proxy.BodyRecords{}, `"/api/users"`},
+		{"templated", proxy.BodyRecords{Path: "/api/users/:id/posts/:postID", RawPath: "/api/users/7/posts/9", PathParams: []proxy.PathParam{{Name: "id", Value: "7"}, {Name: "postID", Value: "9"}}}, `"/api/users/7/posts/9"`},
+		{"params out of line", proxy.BodyRecords{Path: "/api/users/:id", RawPath: "/api/users/7", PathParams: []proxy.PathParam{{Name: "slug", Value: "x"}}}, `"/api/users/7"`},
+		{"raw path only", proxy.BodyRecords{Path: "/api/users", RawPath: "/api/users"}, `"/api/users"`},
+		{"query", proxy.BodyRecords{Path: "/api/users/:id", PathParams: []proxy.PathParam{{Name: "id", Value: "7"}}, Query: url.Values{"full": {"1"}}}, `"/api/users/7?" + url.Values{"full": {"1",},}.Encode()`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.expected, j.rowPath("/api/users", &tt.row))
+		})
+	}
+}
+
+func TestGenRunRowPaths(t *testing.T) {
+	j := &JsonFile{}
+	rows := []proxy.BodyRecords{
+		{Method: "GET", StatusCode: 200, Path: "/api/users", RawPath: "/api/users"},
+		{Method: "GET", StatusCode: 200, Path: "/api/users/:id", RawPath: "/api/users/7", PathParams: []proxy.PathParam{{Name: "id", Value: "7"}}},
+	}
+	table := j.genGetRun("/api/users", rows)
+	require.Contains(t, table, `{name: "users 0", path: "/api/users", expectedStatus: http.StatusOK},`)
+	require.Contains(t, table, `{name: "users 1", path: "/api/users/7", expectedStatus: http.StatusOK},`)
+}
+
+func TestGenTestFunctionMethods(t *testing.T) {
+	j := &JsonFile{}
+	out := j.genTestFunction("/api/users", proxy.Recording{Body: []proxy.BodyRecords{
+		{Method: "DELETE", StatusCode: 204},
+		{Method: "OPTIONS", StatusCode: 204, ResponseHeader: http.Header{"Allow": {"GET, HEAD"}}},
+		{Method: "HEAD", StatusCode: 200},
+		{Method: "GET", StatusCode: 200},
+		{Method: "PATCH", StatusCode: 200, Body: `{"name":"jane"}`},
+	}})
+	require.Contains(t, out, `resp := makeReq(t, app, http.MethodHead, "/api/users", nil)`)
+	require.Contains(t, out, `require.Equal(t, "GET, HEAD", resp.Header.Get("Allow"))`)
+	// PATCH without an annotated struct uses the struct inferred from its body
+	require.Contains(t, out, "payload := UsersPatchRequest{\nName: \"jane\",\n}")
+
+	order := []string{"http.MethodGet", "http.MethodHead", "http.MethodOptions", "http.MethodDelete"}
+	for i := 1; i < len(order); i++ {
+		require.Less(t, strings.Index(out, order[i-1]), strings.Index(out, order[i]))
+	}
+}
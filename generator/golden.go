@@ -0,0 +1,57 @@
+package generator
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const goldenDir = "testdata"
+
+var slugRe = regexp.MustCompile(`[^a-z0-9]+`)
+
+// goldenSlug turns a case name into a file name, "POST users 0" becomes
+// post_users_0.
+func goldenSlug(name string) string {
+	return strings.Trim(slugRe.ReplaceAllString(strings.ToLower(name), "_"), "_")
+}
+
+// addGolden keeps the expected body of a case for writeGoldens and returns
+// the name requireGolden reads it by, <test>/<case>.
+func (j *JsonFile) addGolden(method, name, expected string) string {
+	golden := path.Join(j.testName, goldenSlug(method+" "+name))
+	if j.goldens == nil {
+		j.goldens = make(map[string]string)
+	}
+	j.goldens[golden] = expected
+	return golden
+}
+
+// writeGoldens writes the expected bodies of the generated cases to
+// gentest/testdata/<test>/<case>.golden.json, indented for review. Existing
+// golden files may have been accepted with go test -update, they are only
+// replaced with UpdateGolden.
+func (j *JsonFile) writeGoldens() error {
+	for name, expected := range j.goldens {
+		file := filepath.Join(testFileDir, goldenDir, filepath.FromSlash(name)+".golden.json")
+		if _, err := os.Stat(file); err == nil && !j.UpdateGolden {
+			continue
+		}
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, []byte(expected), "", "  "); err != nil {
+			return err
+		}
+		buf.WriteString("\n")
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(file, buf.Bytes(), 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,430 @@
+package generator
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/muzzii255/testgen/proxy"
+	"github.com/muzzii255/testgen/structgen"
+)
+
+const typesFileName = "types_gen.go"
+
+// shape is the type of a JSON value, merged from every sample seen for it.
+type shape struct {
+	str     bool
+	boolean bool
+	integer bool
+	float   bool
+	null    bool
+	object  *objectShape
+	array   *shape
+}
+
+type objectShape struct {
+	count   int
+	fields  map[string]*shape
+	present map[string]int
+	names   map[string]string
+}
+
+// inferredType is a struct inferred from the bodies of one endpoint and
+// method.
+type inferredType struct {
+	name  string
+	shape *shape
+	// origin is the method and endpoint the type is inferred from, written
+	// to its doc comment so later runs know who owns the name.
+	origin string
+	// used is set once a test refers to the type, only used types are
+	// written.
+	used bool
+}
+
+func (s *shape) merge(v any) {
+	switch t := v.(type) {
+	case nil:
+		s.null = true
+	case string:
+		s.str = true
+	case bool:
+		s.boolean = true
+	case json.Number:
+		if strings.ContainsAny(t.String(), ".eE") {
+			s.float = true
+		} else {
+			s.integer = true
+		}
+	case map[string]any:
+		if s.object == nil {
+			s.object = &objectShape{fields: make(map[string]*shape), present: make(map[string]int)}
+		}
+		s.object.count++
+		for k, fv := range t {
+			if s.object.fields[k] == nil {
+				s.object.fields[k] = &shape{}
+			}
+			s.object.present[k]++
+			s.object.fields[k].merge(fv)
+		}
+	case []any:
+		if s.array == nil {
+			s.array = &shape{}
+		}
+		for _, e := range t {
+			s.array.merge(e)
+		}
+	}
+}
+
+// kinds counts the distinct non-null JSON types seen. Integers and floats are
+// one kind.
+func (s *shape) kinds() int {
+	n := 0
+	for _, seen := range []bool{s.str, s.boolean, s.integer || s.float, s.object != nil, s.array != nil} {
+		if seen {
+			n++
+		}
+	}
+	return n
+}
+
+// goType returns the Go type of s. Objects become the named struct name.
+func (s *shape) goType(name string) string {
+	if s.kinds() != 1 {
+		return "any"
+	}
+	switch {
+	case s.str:
+		return "string"
+	case s.boolean:
+		return "bool"
+	case s.float:
+		return "float64"
+	case s.integer:
+		return "int64"
+	case s.object != nil:
+		return name
+	default:
+		return "[]" + s.array.goType(name)
+	}
+}
+
+// fieldNames maps the keys of an object to unique exported field names, in
+// key order.
+func (o *objectShape) fieldNames() ([]string, map[string]string) {
+	keys := slices.Sorted(maps.Keys(o.fields))
+	if o.names == nil {
+		o.names = make(map[string]string)
+		used := make(map[string]bool)
+		for _, k := range keys {
+			o.names[k] = uniqueName(used, exportName(k))
+		}
+	}
+	return keys, o.names
+}
+
+// optional reports whether a field is missing from some samples.
+func (o *objectShape) optional(key string) bool {
+	return o.present[key] < o.count
+}
+
+// pointer reports whether the field for key is a pointer, which holds for
+// scalars and structs that are missing or null in some samples.
+func (o *objectShape) pointer(key string) bool {
+	f := o.fields[key]
+	if !o.optional(key) && !f.null {
+		return false
+	}
+	typ := f.goType("")
+	return typ != "any" && !strings.HasPrefix(typ, "[]")
+}
+
+// writeDecls adds the declaration of the struct name and of the structs
+// nested in it to decls.
+func (s *shape) writeDecls(name string, decls map[string]string) {
+	switch {
+	case s.kinds() != 1:
+		return
+	case s.array != nil:
+		s.array.writeDecls(name, decls)
+		return
+	case s.object == nil:
+		return
+	}
+	keys, names := s.object.fieldNames()
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "type %s struct {\n", name)
+	for _, k := range keys {
+		f := s.object.fields[k]
+		typ := f.goType(name + names[k])
+		if s.object.pointer(k) {
+			typ = "*" + typ
+		}
+		tag := k
+		if s.object.optional(k) {
+			tag += ",omitempty"
+		}
+		fmt.Fprintf(&sb, "%s %s `json:%q`\n", names[k], typ, tag)
+		f.writeDecls(name+names[k], decls)
+	}
+	sb.WriteString("}")
+	decls[name] = sb.String()
+}
+
+// literal returns the Go expression holding v as a value of s.
+func (j *JsonFile) literal(s *shape, name string, v any, ptr bool, vars map[string]string) string {
+	typ := s.goType(name)
+	if typ == "any" {
+		return j.anyLiteral(v)
+	}
+	switch t := v.(type) {
+	case string:
+		expr := j.stringExpr(t)
+		if ptr {
+			return fmt.Sprintf("Ptr(%s)", expr)
+		}
+		return expr
+	case bool, json.Number:
+		expr := fmt.Sprint(t)
+		if ptr {
+			return fmt.Sprintf("%s(%s)", ptrFunc(typ), expr)
+		}
+		return expr
+	case []any:
+		elems := make([]string, 0, len(t))
+		for _, e := range t {
+			elems = append(elems, j.literal(s.array, name, e, false, nil))
+		}
+		return fmt.Sprintf("%s{%s}", typ, strings.Join(elems, ", "))
+	case map[string]any:
+		keys, names := s.object.fieldNames()
+		var sb strings.Builder
+		if ptr {
+			sb.WriteString("&")
+		}
+		sb.WriteString(name + "{\n")
+		for _, k := range keys {
+			fv, ok := t[k]
+			if !ok || fv == nil {
+				continue
+			}
+			field := s.object.fields[k]
+			ptr := s.object.pointer(k)
+			v, _ := structgen.Var(vars, k)
+			expr, ok := varLiteral(field.goType(name+names[k]), v, ptr)
+			if !ok {
+				expr = j.literal(field, name+names[k], fv, ptr, structgen.SubVars(vars, k))
+			}
+			fmt.Fprintf(&sb, "%s: %s,\n", names[k], expr)
+		}
+		sb.WriteString("}")
+		return sb.String()
+	}
+	return "nil"
+}
+
+// varLiteral returns the value of a field of type typ held by the captured
+// variable v.
+func varLiteral(typ, v string, ptr bool) (string, bool) {
+	var expr string
+	switch {
+	case v == "":
+		return "", false
+	case typ == "string":
+		expr = v
+	case typ == "int64":
+		expr = fmt.Sprintf("parseID(t, %s)", v)
+	case typ == "float64":
+		expr = fmt.Sprintf("float64(parseID(t, %s))", v)
+	default:
+		return "", false
+	}
+	if ptr {
+		expr = "Ptr(" + expr + ")"
+	}
+	return expr, true
+}
+
+// ptrFunc returns the Ptr call for typ. Untyped constants only infer string,
+// bool and int.
+func ptrFunc(typ string) string {
+	switch typ {
+	case "string", "bool":
+		return "Ptr"
+	default:
+		return "Ptr[" + typ + "]"
+	}
+}
+
+// anyLiteral returns a literal of v for fields whose samples disagree on the
+// type.
+func (j *JsonFile) anyLiteral(v any) string {
+	switch t := v.(type) {
+	case nil:
+		return "nil"
+	case string:
+		return j.stringExpr(t)
+	case bool, json.Number:
+		return fmt.Sprint(t)
+	case []any:
+		elems := make([]string, 0, len(t))
+		for _, e := range t {
+			elems = append(elems, j.anyLiteral(e))
+		}
+		return fmt.Sprintf("[]any{%s}", strings.Join(elems, ", "))
+	case map[string]any:
+		var sb strings.Builder
+		sb.WriteString("map[string]any{")
+		for _, k := range slices.Sorted(maps.Keys(t)) {
+			fmt.Fprintf(&sb, "%q: %s, ", k, j.anyLiteral(t[k]))
+		}
+		sb.WriteString("}")
+		return sb.String()
+	}
+	return "nil"
+}
+
+var methodLabels = map[string]string{
+	"POST":  "Create",
+	"PUT":   "Update",
+	"PATCH": "Patch",
+}
+
+func decodeObject(body string) (map[string]any, bool) {
+	dec := json.NewDecoder(strings.NewReader(body))
+	dec.UseNumber()
+	var obj map[string]any
+	if err := dec.Decode(&obj); err != nil || obj == nil {
+		return nil, false
+	}
+	return obj, true
+}
+
+// inferBodies infers the request struct of endpoint and method from the JSON
+// object bodies of rows, unless it was inferred already.
+func (j *JsonFile) inferBodies(endpoint, method string, rows []proxy.BodyRecords) {
+	key := endpoint + " " + method
+	if _, ok := j.inferred[key]; ok {
+		return
+	}
+	s := &shape{}
+	for _, row := range rows {
+		if obj, ok := decodeObject(row.Body); ok && row.Method == method {
+			s.merge(obj)
+		}
+	}
+	if s.object == nil {
+		return
+	}
+	if j.inferred == nil {
+		j.inferred = make(map[string]*inferredType)
+		j.typeNames = make(map[string]bool)
+		_, j.typeOrigins = existingTypes(filepath.Join(testFileDir, typesFileName))
+	}
+	label, ok := methodLabels[method]
+	if !ok {
+		label = exportName(strings.ToLower(method))
+	}
+	// types written for another endpoint keep their name, endpoints sharing
+	// a function name get a numbered one
+	origin := method + " " + endpoint
+	base := exportName(getFuncName(endpoint)) + label + "Request"
+	name := base
+	for i := 2; j.typeNames[name] || j.typeOrigins[name] != "" && j.typeOrigins[name] != origin; i++ {
+		name = fmt.Sprintf("%s%d", base, i)
+	}
+	j.typeNames[name] = true
+	j.inferred[key] = &inferredType{name: name, shape: s, origin: origin}
+}
+
+// inferredPayload maps a body onto the struct inferred for its endpoint.
+func (j *JsonFile) inferredPayload(endpoint string, row *proxy.BodyRecords) (string, string, error) {
+	it := j.inferred[endpoint+" "+row.Method]
+	if it == nil {
+		return "", "", errNoModel
+	}
+	obj, ok := decodeObject(row.Body)
+	if !ok {
+		return "", "", errNotJSON
+	}
+	it.used = true
+	return it.name, j.literal(it.shape, it.name, obj, false, j.chain.bodyVars(row)), nil
+}
+
+// typesSource returns the types file of the generated tests with the inferred
+// structs added, or nil when no inferred struct is used. Types written for
+// other recordings are kept.
+func (j *JsonFile) typesSource() ([]byte, error) {
+	decls, _ := existingTypes(filepath.Join(testFileDir, typesFileName))
+	written := 0
+	for _, it := range j.inferred {
+		if it.used {
+			it.shape.writeDecls(it.name, decls)
+			decls[it.name] = fmt.Sprintf("// %s is inferred from %s.\n%s", it.name, it.origin, decls[it.name])
+			written++
+		}
+	}
+	if written == 0 {
+		return nil, nil
+	}
+	var sb strings.Builder
+	sb.WriteString("// Code generated by testgen. DO NOT EDIT.\n\npackage gentests\n\n")
+	for _, name := range slices.Sorted(maps.Keys(decls)) {
+		sb.WriteString(decls[name] + "\n\n")
+	}
+	src, err := format.Source([]byte(sb.String()))
+	if err != nil {
+		return nil, fmt.Errorf("error formatting %s :%v", typesFileName, err)
+	}
+	return src, nil
+}
+
+// existingTypes returns the type declarations of a previously generated types
+// file and the origin of the inferred ones, by name.
+func existingTypes(path string) (map[string]string, map[string]string) {
+	decls := make(map[string]string)
+	origins := make(map[string]string)
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return decls, origins
+	}
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, path, src, parser.ParseComments|parser.SkipObjectResolution)
+	if err != nil {
+		return decls, origins
+	}
+	for _, decl := range f.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok || gd.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gd.Specs {
+			ts := spec.(*ast.TypeSpec)
+			start, end := fset.Position(ts.Pos()).Offset, fset.Position(ts.End()).Offset
+			decl := "type " + string(src[start:end])
+			doc := ts.Doc
+			if doc == nil && len(gd.Specs) == 1 {
+				doc = gd.Doc
+			}
+			if doc != nil {
+				text := strings.TrimSpace(doc.Text())
+				if origin, ok := strings.CutPrefix(text, ts.Name.Name+" is inferred from "); ok {
+					origins[ts.Name.Name] = strings.TrimSuffix(origin, ".")
+					decl = "// " + text + "\n" + decl
+				}
+			}
+			decls[ts.Name.Name] = decl
+		}
+	}
+	return decls, origins
+}
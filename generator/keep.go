@@ -0,0 +1,471 @@
+package generator
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"log/slog"
+	"maps"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// keepMarker marks the statement or declaration below it as user code that
+// regeneration must not drop.
+const keepMarker = "//testgen:keep"
+
+var versionRe = regexp.MustCompile(`^v[0-9]+$`)
+
+// keptStmt is a marked statement of a generated function, along with the
+// t.Run names of the subtests it was written in and the key of the generated
+// statement it followed.
+type keptStmt struct {
+	fn    string
+	path  []string
+	after string
+	text  string
+}
+
+// keptCode is the user code found in a previously generated test file.
+type keptCode struct {
+	fset    *token.FileSet
+	src     []byte
+	markers []token.Pos
+	fn      string
+	stmts   []keptStmt
+	// funcs holds the generated functions with marked statements, used
+	// whole when the function is no longer generated.
+	funcs map[string]string
+	decls []keptDecl
+	// imports maps the package names of the old file to their import specs,
+	// used names the ones kept code refers to.
+	imports map[string]string
+	used    map[string]bool
+	// subtests holds the t.Run names of the unmarked subtests of generated
+	// functions, by function.
+	subtests map[string][]string
+}
+
+type keptDecl struct {
+	names  []string
+	text   string
+	marked bool
+}
+
+func (k *keptCode) text(from, to token.Pos) string {
+	return string(k.src[k.fset.Position(from).Offset:k.fset.Position(to).Offset])
+}
+
+// marker returns the first keep marker between from and to.
+func (k *keptCode) marker(from, to token.Pos) (token.Pos, bool) {
+	i, _ := slices.BinarySearch(k.markers, from)
+	if i < len(k.markers) && k.markers[i] < to {
+		return k.markers[i], true
+	}
+	return token.NoPos, false
+}
+
+func (k *keptCode) use(n ast.Node) {
+	ast.Inspect(n, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if id, ok := sel.X.(*ast.Ident); ok {
+				k.used[id.Name] = true
+			}
+		}
+		return true
+	})
+}
+
+// runCall returns the name argument and the body of a t.Run call.
+func runCall(src func(from, to token.Pos) string, call *ast.CallExpr) (string, *ast.BlockStmt, bool) {
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok || sel.Sel.Name != "Run" || len(call.Args) != 2 {
+		return "", nil, false
+	}
+	fl, ok := call.Args[1].(*ast.FuncLit)
+	if !ok {
+		return "", nil, false
+	}
+	return src(call.Args[0].Pos(), call.Args[0].End()), fl.Body, true
+}
+
+func (k *keptCode) walk(n ast.Node, path []string) {
+	ast.Inspect(n, func(n ast.Node) bool {
+		switch t := n.(type) {
+		case *ast.CallExpr:
+			if name, body, ok := runCall(k.text, t); ok {
+				path := append(slices.Clip(path), name)
+				k.subtests[k.fn] = append(k.subtests[k.fn], strings.Join(path, " > "))
+				k.block(body, path)
+				return false
+			}
+		case *ast.BlockStmt:
+			k.block(t, path)
+			return false
+		}
+		return true
+	})
+}
+
+// block collects the marked statements of b. The marker and everything
+// below it up to the end of the statement is kept.
+func (k *keptCode) block(b *ast.BlockStmt, path []string) {
+	prev, after := b.Lbrace, ""
+	for _, stmt := range b.List {
+		if marker, ok := k.marker(prev, stmt.Pos()); ok {
+			k.stmts = append(k.stmts, keptStmt{fn: k.fn, path: path, after: after, text: k.text(marker, stmt.End())})
+			k.use(stmt)
+		} else {
+			k.walk(stmt, path)
+			after = stmtKey(k.text, stmt)
+		}
+		prev = stmt.End()
+	}
+}
+
+// stmtKey identifies a statement across regenerations: subtests by their
+// name, anything else by its source without white space.
+func stmtKey(src func(from, to token.Pos) string, stmt ast.Stmt) string {
+	key := ""
+	ast.Inspect(stmt, func(n ast.Node) bool {
+		if call, ok := n.(*ast.CallExpr); ok && key == "" {
+			if name, _, ok := runCall(src, call); ok {
+				key = "t.Run " + name
+			}
+		}
+		return key == ""
+	})
+	if key != "" {
+		return key
+	}
+	return strings.Join(strings.Fields(src(stmt.Pos(), stmt.End())), "")
+}
+
+// insertOffset returns where a statement kept after the statement keyed
+// after goes in block: right below it, at the top of the block when it
+// came first, or at the end when it is gone.
+func insertOffset(src func(from, to token.Pos) string, block *ast.BlockStmt, after string) token.Pos {
+	if after == "" {
+		return block.Lbrace + 1
+	}
+	for _, stmt := range block.List {
+		if stmtKey(src, stmt) == after {
+			return stmt.End()
+		}
+	}
+	return block.Rbrace
+}
+
+// generatedFunc reports whether fd has the shape of the functions testgen
+// writes, func testName(t *testing.T).
+func generatedFunc(fd *ast.FuncDecl) bool {
+	if fd.Recv != nil || !strings.HasPrefix(fd.Name.Name, "test") || len(fd.Type.Params.List) != 1 {
+		return false
+	}
+	star, ok := fd.Type.Params.List[0].Type.(*ast.StarExpr)
+	if !ok {
+		return false
+	}
+	sel, ok := star.X.(*ast.SelectorExpr)
+	return ok && sel.Sel.Name == "T"
+}
+
+func declNames(decl ast.Decl) []string {
+	names := make([]string, 0)
+	switch d := decl.(type) {
+	case *ast.FuncDecl:
+		if d.Recv == nil {
+			names = append(names, d.Name.Name)
+		}
+	case *ast.GenDecl:
+		for _, spec := range d.Specs {
+			switch s := spec.(type) {
+			case *ast.TypeSpec:
+				names = append(names, s.Name.Name)
+			case *ast.ValueSpec:
+				for _, n := range s.Names {
+					names = append(names, n.Name)
+				}
+			}
+		}
+	}
+	return names
+}
+
+// runNames adds the t.Run names of the subtests in n to names, nested ones
+// joined to their parent by " > ".
+func runNames(src func(from, to token.Pos) string, n ast.Node, parent string, names map[string]bool) {
+	ast.Inspect(n, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		name, body, ok := runCall(src, call)
+		if !ok {
+			return true
+		}
+		if parent != "" {
+			name = parent + " > " + name
+		}
+		names[name] = true
+		runNames(src, body, name, names)
+		return false
+	})
+}
+
+// subtest is a t.Run case of a generated function.
+type subtest struct {
+	fn, name string
+}
+
+// dropped returns the unmarked subtests of the old file that regeneration
+// loses. Functions kept whole keep theirs.
+func (k *keptCode) dropped(funcs map[string]*ast.FuncDecl, src func(from, to token.Pos) string) []subtest {
+	lost := make([]subtest, 0)
+	for _, fn := range slices.Sorted(maps.Keys(k.subtests)) {
+		fd, ok := funcs[fn]
+		if !ok && k.funcs[fn] != "" {
+			continue
+		}
+		names := make(map[string]bool)
+		if ok {
+			runNames(src, fd.Body, "", names)
+		}
+		for _, name := range slices.Compact(k.subtests[fn]) {
+			if !names[name] {
+				lost = append(lost, subtest{fn: fn, name: name})
+			}
+		}
+	}
+	return lost
+}
+
+// importName guesses the package name of an import path, its last element
+// without a major version suffix.
+func importName(path string) string {
+	parts := strings.Split(path, "/")
+	name := parts[len(parts)-1]
+	if versionRe.MatchString(name) && len(parts) > 1 {
+		name = parts[len(parts)-2]
+	}
+	name = strings.TrimPrefix(name, "go-")
+	if i := strings.IndexAny(name, ".-"); i > 0 {
+		name = name[:i]
+	}
+	return name
+}
+
+// readKept finds the user code of a previously generated file: statements
+// marked with //testgen:keep and every top-level declaration testgen does
+// not generate itself.
+func readKept(src []byte) (*keptCode, error) {
+	k := &keptCode{
+		fset:     token.NewFileSet(),
+		src:      src,
+		funcs:    make(map[string]string),
+		imports:  make(map[string]string),
+		used:     make(map[string]bool),
+		subtests: make(map[string][]string),
+	}
+	f, err := parser.ParseFile(k.fset, "", src, parser.ParseComments|parser.SkipObjectResolution)
+	if err != nil {
+		return nil, err
+	}
+	for _, cg := range f.Comments {
+		for _, c := range cg.List {
+			if strings.HasPrefix(c.Text, keepMarker) {
+				k.markers = append(k.markers, c.Pos())
+			}
+		}
+	}
+	for _, imp := range f.Imports {
+		path, _ := strconv.Unquote(imp.Path.Value)
+		name := importName(path)
+		if imp.Name != nil {
+			name = imp.Name.Name
+		}
+		k.imports[name] = k.text(imp.Pos(), imp.End())
+	}
+
+	for _, decl := range f.Decls {
+		if gd, ok := decl.(*ast.GenDecl); ok && gd.Tok == token.IMPORT {
+			continue
+		}
+		start := decl.Pos()
+		var doc *ast.CommentGroup
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			doc = d.Doc
+		case *ast.GenDecl:
+			doc = d.Doc
+		}
+		if doc != nil {
+			start = doc.Pos()
+		}
+		_, marked := k.marker(start, decl.Pos())
+		fd, ok := decl.(*ast.FuncDecl)
+		if ok && generatedFunc(fd) && !marked {
+			k.fn = fd.Name.Name
+			n := len(k.stmts)
+			k.block(fd.Body, nil)
+			if len(k.stmts) > n {
+				k.funcs[fd.Name.Name] = k.text(start, decl.End())
+			}
+			continue
+		}
+		k.decls = append(k.decls, keptDecl{names: declNames(decl), text: k.text(start, decl.End()), marked: marked})
+		k.use(decl)
+	}
+	return k, nil
+}
+
+// edit replaces the bytes between start and end of a generated file.
+type edit struct {
+	start, end int
+	text       string
+}
+
+// findBlock returns the body of the subtests named by path, or the deepest
+// of them that still exists.
+func findBlock(src func(from, to token.Pos) string, body *ast.BlockStmt, path []string) *ast.BlockStmt {
+	for _, name := range path {
+		var next *ast.BlockStmt
+		ast.Inspect(body, func(n ast.Node) bool {
+			if next != nil {
+				return false
+			}
+			if call, ok := n.(*ast.CallExpr); ok {
+				if got, b, ok := runCall(src, call); ok && got == name {
+					next = b
+					return false
+				}
+			}
+			return true
+		})
+		if next == nil {
+			break
+		}
+		body = next
+	}
+	return body
+}
+
+// mergeKept adds the user code of the previously generated file old to the
+// freshly generated src. Marked statements go below the generated statement
+// they followed in the subtest they were written in, other declarations to
+// the end of the file. A marked
+// generated function replaces the generated one. Generated functions that
+// are gone are dropped, unless they hold marked statements.
+func mergeKept(old, src []byte) ([]byte, error) {
+	k, err := readKept(old)
+	if err != nil {
+		return nil, fmt.Errorf("parsing existing file :%v", err)
+	}
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", src, parser.ParseComments|parser.SkipObjectResolution)
+	if err != nil {
+		return nil, fmt.Errorf("parsing generated file :%v", err)
+	}
+	offset := func(pos token.Pos) int { return fset.Position(pos).Offset }
+	text := func(from, to token.Pos) string { return string(src[offset(from):offset(to)]) }
+
+	funcs := make(map[string]*ast.FuncDecl)
+	declared := make(map[string]ast.Decl)
+	imported := make(map[string]bool)
+	var importDecl *ast.GenDecl
+	for _, decl := range f.Decls {
+		if gd, ok := decl.(*ast.GenDecl); ok && gd.Tok == token.IMPORT {
+			importDecl = gd
+			for _, imp := range gd.Specs {
+				path, _ := strconv.Unquote(imp.(*ast.ImportSpec).Path.Value)
+				imported[path] = true
+			}
+			continue
+		}
+		if fd, ok := decl.(*ast.FuncDecl); ok {
+			funcs[fd.Name.Name] = fd
+		}
+		for _, name := range declNames(decl) {
+			declared[name] = decl
+		}
+	}
+
+	edits := make([]edit, 0)
+	tail := make([]string, 0)
+	replaced := make(map[string]bool)
+	for _, decl := range k.decls {
+		var existing ast.Decl
+		for _, name := range decl.names {
+			if d, ok := declared[name]; ok {
+				existing = d
+			}
+		}
+		switch {
+		case existing == nil:
+			tail = append(tail, decl.text)
+		case decl.marked:
+			fd, ok := existing.(*ast.FuncDecl)
+			if !ok {
+				slog.Warn("dropping kept declaration clashing with generated code", "names", decl.names)
+				continue
+			}
+			start := fd.Pos()
+			if fd.Doc != nil {
+				start = fd.Doc.Pos()
+			}
+			edits = append(edits, edit{start: offset(start), end: offset(fd.End()), text: decl.text})
+			replaced[fd.Name.Name] = true
+		default:
+			slog.Warn("dropping declaration replaced by generated code", "names", decl.names)
+		}
+	}
+	for _, lost := range k.dropped(funcs, text) {
+		slog.Warn("dropping test case that is no longer generated, mark it with //testgen:keep to keep it", "func", lost.fn, "case", lost.name)
+	}
+	for _, stmt := range k.stmts {
+		fd, ok := funcs[stmt.fn]
+		if !ok || replaced[stmt.fn] {
+			continue
+		}
+		block := findBlock(text, fd.Body, stmt.path)
+		at := offset(insertOffset(text, block, stmt.after))
+		edits = append(edits, edit{start: at, end: at, text: "\n" + stmt.text + "\n"})
+	}
+	for _, name := range slices.Sorted(maps.Keys(k.funcs)) {
+		if _, ok := funcs[name]; !ok {
+			slog.Warn("keeping function with //testgen:keep code that is no longer generated", "func", name)
+			tail = append(tail, k.funcs[name])
+		}
+	}
+	if importDecl != nil && importDecl.Rparen.IsValid() {
+		at := offset(importDecl.Rparen)
+		for _, name := range slices.Sorted(maps.Keys(k.used)) {
+			spec, ok := k.imports[name]
+			if !ok {
+				continue
+			}
+			path, err := strconv.Unquote(spec[strings.Index(spec, `"`):])
+			if err == nil && !imported[path] {
+				imported[path] = true
+				edits = append(edits, edit{start: at, end: at, text: spec + "\n"})
+			}
+		}
+	}
+
+	// apply the edits from the end so earlier offsets stay valid, texts
+	// inserted at the same offset are applied last first to keep their order
+	slices.Reverse(edits)
+	sort.SliceStable(edits, func(a, b int) bool { return edits[a].start > edits[b].start })
+	merged := slices.Clone(src)
+	for _, e := range edits {
+		merged = slices.Concat(merged[:e.start], []byte(e.text), merged[e.end:])
+	}
+	for _, t := range tail {
+		merged = append(merged, []byte("\n"+t+"\n")...)
+	}
+	return merged, nil
+}
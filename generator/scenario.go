@@ -0,0 +1,166 @@
+package generator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/muzzii255/testgen/proxy"
+)
+
+const scenarioFileName = "scenarios_test.go"
+
+// DefaultScenarioGap is the idle time after which a client's requests start
+// a new scenario. Clients are told apart by host only, so without a gap a
+// recording made against localhost would be a single scenario.
+const DefaultScenarioGap = 30 * time.Second
+
+var methodMap = map[string]string{
+	"GET":     "http.MethodGet",
+	"HEAD":    "http.MethodHead",
+	"POST":    "http.MethodPost",
+	"PUT":     "http.MethodPut",
+	"PATCH":   "http.MethodPatch",
+	"DELETE":  "http.MethodDelete",
+	"OPTIONS": "http.MethodOptions",
+}
+
+// scenario is one recorded user flow: the requests a single client made
+// without pausing for longer than the configured gap.
+type scenario struct {
+	client string
+	steps  []proxy.TimelineEntry
+}
+
+func methodExpr(method string) string {
+	if m, ok := methodMap[method]; ok {
+		return m
+	}
+	return fmt.Sprintf("%q", method)
+}
+
+// clientHost drops the port from a recorded client address, so a client
+// opening several connections is still seen as one.
+func clientHost(client string) string {
+	host, _, err := net.SplitHostPort(client)
+	if err != nil {
+		return client
+	}
+	return host
+}
+
+// splitScenarios groups a timeline by client and starts a new scenario
+// whenever a client was idle for longer than gap. A zero gap never splits.
+func splitScenarios(timeline []proxy.TimelineEntry, gap time.Duration) []scenario {
+	scenarios := make([]scenario, 0)
+	open := make(map[string]int)
+	for _, entry := range timeline {
+		client := clientHost(entry.Client)
+		idx, ok := open[client]
+		if ok && gap > 0 {
+			steps := scenarios[idx].steps
+			if entry.Timestamp.Sub(steps[len(steps)-1].Timestamp) > gap {
+				ok = false
+			}
+		}
+		if !ok {
+			scenarios = append(scenarios, scenario{client: client})
+			idx = len(scenarios) - 1
+			open[client] = idx
+		}
+		scenarios[idx].steps = append(scenarios[idx].steps, entry)
+	}
+	return scenarios
+}
+
+// stepCase builds the request of a single scenario step. Bodies are mapped
+// onto the struct of the endpoint, or sent as raw JSON when they aren't JSON
+// objects.
+func (j *JsonFile) stepCase(name string, entry *proxy.TimelineEntry) testCase {
+	row := &entry.BodyRecords
+	tc := j.newTestCase(name, entry.Endpoint, row)
+	if entry.Body == "" {
+		return tc
+	}
+	_, payload, err := j.payload(entry.Endpoint, row)
+	if err == nil {
+		tc.payload = payload
+		return tc
+	}
+	if !errors.Is(err, errNoModel) && !errors.Is(err, errNotJSON) {
+		slog.Error("error parsing struct during codegeneration", "endpoint", entry.Endpoint, "err", err)
+	}
+	if json.Valid([]byte(entry.Body)) {
+		tc.payload = fmt.Sprintf("json.RawMessage(%q)", entry.Body)
+	}
+	return tc
+}
+
+// genScenarioFunction writes one test running the steps of sc in recorded
+// order against the same app. A failed step ends the scenario since later
+// steps usually depend on it.
+func (j *JsonFile) genScenarioFunction(n int, sc scenario) string {
+	flow := make([]string, 0, len(sc.steps))
+	for _, step := range sc.steps {
+		flow = append(flow, step.Method+" "+step.Path)
+	}
+	rows := make([]*proxy.BodyRecords, 0, len(sc.steps))
+	endpoints := make([]string, 0, len(sc.steps))
+	for i := range sc.steps {
+		rows = append(rows, &sc.steps[i].BodyRecords)
+		endpoints = append(endpoints, sc.steps[i].Endpoint)
+	}
+	j.chain = planChain(rows, endpoints)
+	j.testName = fmt.Sprintf("scenario%d", n)
+	j.rows = make(map[*proxy.BodyRecords]int, len(rows))
+	for _, row := range rows {
+		j.rows[row] = slices.IndexFunc(j.timeline, func(e proxy.TimelineEntry) bool {
+			return e.Seq == row.Seq && e.Client == row.Client && e.Timestamp.Equal(row.Timestamp)
+		})
+	}
+	defer func() { j.chain, j.testName, j.rows = nil, "", nil }()
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "// testScenario%d replays the requests made by %s: %s\n", n, sc.client, strings.Join(flow, " -> "))
+	fmt.Fprintf(&sb, "func testScenario%d(t *testing.T){\n", n)
+	sb.WriteString("app := setup()\n\n")
+	sb.WriteString(j.chain.declarations())
+	for i := range sc.steps {
+		step := &sc.steps[i]
+		label := fmt.Sprintf("%d %s %s", i+1, step.Method, step.Path)
+		fmt.Fprintf(&sb, "if !t.Run(%q, func(t *testing.T) {\n", label)
+		tc := j.stepCase(fmt.Sprintf("step %d", i+1), step)
+		j.aliasOrigin(label, tc.name)
+		writeCall(&sb, methodExpr(step.Method), tc)
+		sb.WriteString("}) {\nreturn\n}\n\n")
+	}
+	sb.WriteString("}\n\n")
+	return sb.String()
+}
+
+func (j *JsonFile) genScenarios() string {
+	bodies := make(map[string][]proxy.BodyRecords)
+	endpoints := make([]string, 0)
+	for _, entry := range j.timeline {
+		key := entry.Endpoint + " " + entry.Method
+		if _, ok := bodies[key]; !ok {
+			endpoints = append(endpoints, key)
+		}
+		bodies[key] = append(bodies[key], entry.BodyRecords)
+	}
+	for _, key := range endpoints {
+		endpoint, method, _ := strings.Cut(key, " ")
+		j.inferBodies(endpoint, method, bodies[key])
+	}
+
+	var sb strings.Builder
+	for i, sc := range splitScenarios(j.timeline, j.ScenarioGap) {
+		sb.WriteString(j.genScenarioFunction(i+1, sc))
+	}
+	return sb.String()
+}
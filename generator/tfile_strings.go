@@ -1,42 +1,60 @@
 package generator
 
-var mainTest = `package gentests
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+var mainTest = template.Must(template.New("main_test.go").Parse(`package gentests
 
 import (
-	"bytes"
-	"encoding/json"
+{{- if eq .Import "net/http"}}
 	"net/http"
-	"net/http/httptest"
+{{- end}}
 	"testing"
+{{- if ne .Import "net/http"}}
 
-	"github.com/gofiber/fiber/v3"
+	"{{.Import}}"
+{{- end}}
 )
 
-var testApp *fiber.App
+var testApp {{.AppType}}
 
 func TestMain(m *testing.M) {
 }
 
-func setup() *fiber.App {
+func setup() {{.AppType}} {
 	return testApp
 }
 
-`
+`))
+
+var helperFile = template.Must(template.New(helpersFileName).Parse(`// Code generated by testgen. DO NOT EDIT.
 
-var helperFile = `package gentests
+package gentests
 
 import (
 	"bytes"
 	"encoding/json"
+	"flag"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
-	"github.com/gofiber/fiber/v3"
+{{if ne .Import "net/http"}}
+	"{{.Import}}"
+{{- end}}
 	"github.com/stretchr/testify/require"
 )
 
-func makeReq(t *testing.T, app *fiber.App, method, path string, body any) *http.Response {
+func makeReq(t *testing.T, app {{.AppType}}, method, path string, body any, headers ...http.Header) *http.Response {
 	var reader io.Reader
 	if body != nil {
 		b, err := json.Marshal(body)
@@ -45,13 +63,26 @@ func makeReq(t *testing.T, app *fiber.App, method, path string, body any) *http.
 	}
 	req := httptest.NewRequest(method, path, reader)
 	req.Header.Set("content-type", "application/json")
-
-	resp, err := app.Test(req)
+	for _, h := range headers {
+		for k, v := range h {
+			req.Header[k] = v
+		}
+	}
+{{if .Test}}
+	resp, err := {{.Test}}
 	require.NoError(t, err)
 	return resp
+{{- else}}
+	rec := httptest.NewRecorder()
+	app.ServeHTTP(rec, req)
+	return rec.Result()
+{{- end}}
 }
 
-func decodeResp[T any](t *testing.T, resp *http.Response) (T, []byte) {
+` + responseHelpers))
+
+// responseHelpers are the helpers shared by every harness to read responses.
+const responseHelpers = `func decodeResp[T any](t *testing.T, resp *http.Response) (T, []byte) {
 	body, err := io.ReadAll(resp.Body)
 	require.NoError(t, err)
 
@@ -61,7 +92,322 @@ func decodeResp[T any](t *testing.T, resp *http.Response) (T, []byte) {
 	return v, body
 }
 
+// requireJSON compares a response body with the recorded one. In expected,
+// the strings <string>, <number>, <bool>, <object> and <array> only check
+// the type of a value and <any> accepts any value or a missing field.
+func requireJSON(t *testing.T, body []byte, expected string) {
+	t.Helper()
+	var want, got any
+	require.NoError(t, decodeJSON([]byte(expected), &want))
+	require.NoError(t, decodeJSON(body, &got), "response body is not JSON: %s", body)
+	diffs := make([]string, 0)
+	matchJSON("$", want, got, &diffs)
+	if len(diffs) > 0 {
+		slices.Sort(diffs)
+		require.Fail(t, "response body does not match the recording", strings.Join(diffs, "\n"))
+	}
+}
+
+func decodeJSON(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+func matchJSON(path string, want, got any, diffs *[]string) {
+	switch w := want.(type) {
+	case string:
+		if ok, matcher := matchType(w, got); matcher {
+			if !ok {
+				*diffs = append(*diffs, fmt.Sprintf("%s: want %s, got %s", path, w, encodeJSON(got)))
+			}
+			return
+		}
+	case map[string]any:
+		g, ok := got.(map[string]any)
+		if !ok {
+			break
+		}
+		for k, wv := range w {
+			gv, ok := g[k]
+			if !ok {
+				if wv != "<any>" {
+					*diffs = append(*diffs, fmt.Sprintf("%s.%s: missing", path, k))
+				}
+				continue
+			}
+			matchJSON(path+"."+k, wv, gv, diffs)
+		}
+		for k, gv := range g {
+			if _, ok := w[k]; !ok {
+				*diffs = append(*diffs, fmt.Sprintf("%s.%s: unexpected %s", path, k, encodeJSON(gv)))
+			}
+		}
+		return
+	case []any:
+		g, ok := got.([]any)
+		if !ok {
+			break
+		}
+		if len(w) != len(g) {
+			*diffs = append(*diffs, fmt.Sprintf("%s: want %d items, got %d", path, len(w), len(g)))
+		}
+		for i := range min(len(w), len(g)) {
+			matchJSON(fmt.Sprintf("%s[%d]", path, i), w[i], g[i], diffs)
+		}
+		return
+	}
+	if want != got {
+		*diffs = append(*diffs, fmt.Sprintf("%s: want %s, got %s", path, encodeJSON(want), encodeJSON(got)))
+	}
+}
+
+// matchType checks got against a type matcher. matcher is false when want
+// is a plain string.
+func matchType(want string, got any) (ok, matcher bool) {
+	switch want {
+	case "<any>":
+		return true, true
+	case "<string>":
+		_, ok = got.(string)
+	case "<number>":
+		_, ok = got.(json.Number)
+	case "<bool>":
+		_, ok = got.(bool)
+	case "<object>":
+		_, ok = got.(map[string]any)
+	case "<array>":
+		_, ok = got.([]any)
+	default:
+		return false, false
+	}
+	return ok, true
+}
+
+func encodeJSON(v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+var update = flag.Bool("update", false, "rewrite the golden files with the responses received")
+
+// requireGolden compares a response body with testdata/<name>.golden.json,
+// see requireJSON. With -update the file is rewritten with body instead,
+// keeping the matchers of values that still have their type.
+func requireGolden(t *testing.T, body []byte, name string) {
+	t.Helper()
+	path := filepath.Join("testdata", name+".golden.json")
+	if !*update {
+		expected, err := os.ReadFile(path)
+		require.NoError(t, err, "run the tests with -update to create the golden file")
+		requireJSON(t, body, string(expected))
+		return
+	}
+	var got, old any
+	require.NoError(t, decodeJSON(body, &got), "response body is not JSON: %s", body)
+	if data, err := os.ReadFile(path); err == nil && decodeJSON(data, &old) == nil {
+		got = keepMatchers(old, got)
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	require.NoError(t, enc.Encode(got))
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	require.NoError(t, os.WriteFile(path, buf.Bytes(), 0o644))
+}
+
+// keepMatchers copies the matchers of a previous golden file into got where
+// the new values still match them.
+func keepMatchers(old, got any) any {
+	switch o := old.(type) {
+	case string:
+		if ok, matcher := matchType(o, got); matcher && ok {
+			return o
+		}
+	case map[string]any:
+		g, ok := got.(map[string]any)
+		if !ok {
+			break
+		}
+		for k, ov := range o {
+			if _, ok := g[k]; !ok && ov == "<any>" {
+				g[k] = ov
+			}
+		}
+		for k, v := range g {
+			g[k] = keepMatchers(o[k], v)
+		}
+	case []any:
+		g, ok := got.([]any)
+		if !ok || len(o) == 0 {
+			break
+		}
+		for i, v := range g {
+			g[i] = keepMatchers(o[min(i, len(o)-1)], v)
+		}
+	}
+	return got
+}
 
 func Ptr[T any](v T) *T { return &v }
 
+// parseID converts an ID captured from a response for a numeric request
+// field.
+func parseID(t *testing.T, s string) int64 {
+	t.Helper()
+	n, err := strconv.ParseInt(s, 10, 64)
+	require.NoError(t, err, "captured ID %q is not a number", s)
+	return n
+}
+
 `
+
+var externalMainTest = template.Must(template.New("main_test.go").Parse(`package gentests
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"testing"
+)
+
+var testApp *http.Client
+
+func TestMain(m *testing.M) {
+	client, err := newClient()
+	if err != nil {
+		log.Fatal(err)
+	}
+	testApp = client
+	os.Exit(m.Run())
+}
+
+func setup() *http.Client {
+	return testApp
+}
+
+`))
+
+var externalHelperFile = template.Must(template.New(helpersFileName).Parse(`// Code generated by testgen. DO NOT EDIT.
+
+package gentests
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newClient configures the client sending requests to TESTGEN_BASE_URL from
+// the environment:
+//
+//	TESTGEN_TIMEOUT               request timeout, 30s by default
+//	TESTGEN_CA_FILE               PEM file with extra CAs to trust
+//	TESTGEN_INSECURE_SKIP_VERIFY  set to true to skip certificate checks
+//
+// Cookies set by the server are kept for later requests and redirects are
+// not followed, so recorded redirect statuses can be checked.
+func newClient() (*http.Client, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	timeout := 30 * time.Second
+	if v := os.Getenv("TESTGEN_TIMEOUT"); v != "" {
+		timeout, err = time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("TESTGEN_TIMEOUT: %w", err)
+		}
+	}
+	tlsConfig := &tls.Config{}
+	if v := os.Getenv("TESTGEN_INSECURE_SKIP_VERIFY"); v != "" {
+		tlsConfig.InsecureSkipVerify, err = strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("TESTGEN_INSECURE_SKIP_VERIFY: %w", err)
+		}
+	}
+	if path := os.Getenv("TESTGEN_CA_FILE"); path != "" {
+		pem, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("TESTGEN_CA_FILE: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("TESTGEN_CA_FILE: no certificates in %s", path)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{
+		Jar:       jar,
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}, nil
+}
+
+func makeReq(t *testing.T, app *http.Client, method, path string, body any, headers ...http.Header) *http.Response {
+	base := os.Getenv("TESTGEN_BASE_URL")
+	if base == "" {
+		t.Skip("TESTGEN_BASE_URL is not set")
+	}
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		require.NoError(t, err)
+		reader = bytes.NewReader(b)
+	}
+	// t.Context needs Go 1.24. The client timeout bounds the request, which
+	// is canceled once the test ends since the body is read after makeReq.
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(base, "/")+path, reader)
+	require.NoError(t, err)
+	req.Header.Set("content-type", "application/json")
+	for _, h := range headers {
+		for k, v := range h {
+			req.Header[k] = v
+		}
+	}
+
+	resp, err := app.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+` + responseHelpers))
+
+func render(tmpl *template.Template, h harness) (string, error) {
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, h); err != nil {
+		return "", fmt.Errorf("error rendering %s :%v", tmpl.Name(), err)
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,290 @@
+package har
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/muzzii255/testgen/proxy"
+)
+
+// The types below cover the subset of the HTTP Archive 1.2 format that maps
+// onto recordings. Unknown fields are ignored when reading.
+
+type File struct {
+	Log Log `json:"log"`
+}
+
+type Log struct {
+	Version string  `json:"version"`
+	Creator Creator `json:"creator"`
+	Entries []Entry `json:"entries"`
+}
+
+type Creator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type Entry struct {
+	StartedDateTime time.Time `json:"startedDateTime"`
+	Time            float64   `json:"time"`
+	Request         Request   `json:"request"`
+	Response        Response  `json:"response"`
+	Cache           struct{}  `json:"cache"`
+	Timings         Timings   `json:"timings"`
+}
+
+type Request struct {
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []NameValue `json:"cookies"`
+	Headers     []NameValue `json:"headers"`
+	QueryString []NameValue `json:"queryString"`
+	PostData    *PostData   `json:"postData,omitempty"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int         `json:"bodySize"`
+}
+
+type Response struct {
+	Status      int         `json:"status"`
+	StatusText  string      `json:"statusText"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []NameValue `json:"cookies"`
+	Headers     []NameValue `json:"headers"`
+	Content     Content     `json:"content"`
+	RedirectURL string      `json:"redirectURL"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int         `json:"bodySize"`
+}
+
+type NameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type PostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type Content struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type Timings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+func ReadFile(filename string) (*File, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("reading har %s: %w", filename, err)
+	}
+	var f File
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("parsing har %s: %w", filename, err)
+	}
+	return &f, nil
+}
+
+func WriteFile(filename string, f *File) error {
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshalling har: %w", err)
+	}
+	if err := os.WriteFile(filename, data, 0o644); err != nil {
+		return fmt.Errorf("writing har %s: %w", filename, err)
+	}
+	return nil
+}
+
+// ToRecordings groups HAR entries into recordings keyed by endpoint, using
+// templater to derive endpoints from paths. Every entry is scrubbed by
+// redactor (nil keeps it as is). Unless all is set, entries that don't look
+// like API traffic (scripts, styles, images...) are skipped.
+func ToRecordings(f *File, all bool, templater *proxy.Templater, redactor *proxy.Redactor) (map[string]proxy.Recording, error) {
+	recordings := make(map[string]proxy.Recording)
+	for i, entry := range f.Log.Entries {
+		if !all && !isAPI(entry) {
+			continue
+		}
+		u, err := url.Parse(entry.Request.URL)
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: parsing url %s: %w", i, entry.Request.URL, err)
+		}
+		respBody := entry.Response.Content.Text
+		if entry.Response.Content.Encoding == "base64" {
+			b, err := base64.StdEncoding.DecodeString(respBody)
+			if err != nil {
+				return nil, fmt.Errorf("entry %d: decoding response body: %w", i, err)
+			}
+			respBody = string(b)
+		}
+		row := proxy.BodyRecords{
+			RawPath:      u.Path,
+			RawQuery:     u.RawQuery,
+			Header:       toHeader(entry.Request.Headers),
+			Method:       entry.Request.Method,
+			StatusCode:   entry.Response.Status,
+			ResponseBody: respBody,
+			Timestamp:    entry.StartedDateTime,
+			Seq:          int64(i + 1),
+		}
+		if entry.Request.PostData != nil {
+			row.Body = entry.Request.PostData.Text
+		}
+		row.ResponseHeader = toHeader(entry.Response.Headers)
+		if u.RawQuery != "" {
+			// parsed before redaction, which scrubs query parameters too
+			row.Query = u.Query()
+		}
+		redactor.Redact(&row)
+		templater.Append(recordings, row, row.Header)
+	}
+	return recordings, nil
+}
+
+// FromRecordings converts recordings into a HAR file. Recorded paths are
+// relative, so baseURL is used to build absolute request URLs.
+func FromRecordings(recordings map[string]proxy.Recording, baseURL string) (*File, error) {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing base url %s: %w", baseURL, err)
+	}
+
+	entries := make([]Entry, 0)
+	for _, rcrd := range recordings {
+		headers := make([]NameValue, 0, len(rcrd.Headers))
+		for _, k := range slices.Sorted(maps.Keys(rcrd.Headers)) {
+			headers = append(headers, NameValue{Name: k, Value: rcrd.Headers[k]})
+		}
+
+		for _, row := range rcrd.Body {
+			path := row.RawPath
+			if path == "" {
+				path = row.Path
+			}
+			reqURL := base.JoinPath(path)
+			reqURL.RawQuery = row.RawQuery
+			query := make([]NameValue, 0)
+			for _, k := range slices.Sorted(maps.Keys(row.Query)) {
+				for _, v := range row.Query[k] {
+					query = append(query, NameValue{Name: k, Value: v})
+				}
+			}
+			reqHeaders := headers
+			if row.Header != nil {
+				reqHeaders = fromHeader(row.Header)
+			}
+			entry := Entry{
+				StartedDateTime: row.Timestamp,
+				Request: Request{
+					Method:      row.Method,
+					URL:         reqURL.String(),
+					HTTPVersion: "HTTP/1.1",
+					Cookies:     []NameValue{},
+					Headers:     reqHeaders,
+					QueryString: query,
+					HeadersSize: -1,
+					BodySize:    len(row.Body),
+				},
+				Response: Response{
+					Status:      row.StatusCode,
+					StatusText:  http.StatusText(row.StatusCode),
+					HTTPVersion: "HTTP/1.1",
+					Cookies:     []NameValue{},
+					Headers:     fromHeader(row.ResponseHeader),
+					Content: Content{
+						Size:     len(row.ResponseBody),
+						MimeType: mimeType(row.ResponseBody),
+						Text:     row.ResponseBody,
+					},
+					HeadersSize: -1,
+					BodySize:    len(row.ResponseBody),
+				},
+				Timings: Timings{Send: -1, Wait: -1, Receive: -1},
+			}
+			if row.Body != "" {
+				entry.Request.PostData = &PostData{
+					MimeType: mimeType(row.Body),
+					Text:     row.Body,
+				}
+			}
+			entries = append(entries, entry)
+		}
+	}
+	sort.SliceStable(entries, func(a, b int) bool {
+		return entries[a].StartedDateTime.Before(entries[b].StartedDateTime)
+	})
+
+	return &File{Log: Log{
+		Version: "1.2",
+		Creator: Creator{Name: "testgen"},
+		Entries: entries,
+	}}, nil
+}
+
+func isAPI(entry Entry) bool {
+	for _, h := range entry.Response.Headers {
+		if strings.EqualFold(h.Name, "Content-Type") && strings.Contains(h.Value, "json") {
+			return true
+		}
+	}
+	if strings.Contains(entry.Response.Content.MimeType, "json") {
+		return true
+	}
+	if entry.Request.PostData != nil && strings.Contains(entry.Request.PostData.MimeType, "json") {
+		return true
+	}
+	return entry.Response.Content.Text == "" && entry.Request.Method != http.MethodGet
+}
+
+func toHeader(values []NameValue) http.Header {
+	if len(values) == 0 {
+		return nil
+	}
+	header := make(http.Header)
+	for _, v := range values {
+		// HTTP/2 pseudo headers like :authority have no place in a request
+		if strings.HasPrefix(v.Name, ":") {
+			continue
+		}
+		header.Add(v.Name, v.Value)
+	}
+	return header
+}
+
+func fromHeader(header http.Header) []NameValue {
+	values := make([]NameValue, 0, len(header))
+	for _, k := range slices.Sorted(maps.Keys(header)) {
+		for _, v := range header[k] {
+			values = append(values, NameValue{Name: k, Value: v})
+		}
+	}
+	return values
+}
+
+func mimeType(body string) string {
+	if body == "" {
+		return ""
+	}
+	if json.Valid([]byte(body)) {
+		return "application/json"
+	}
+	return "text/plain"
+}
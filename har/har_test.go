@@ -0,0 +1,119 @@
+package har
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/muzzii255/testgen/proxy"
+	"github.com/stretchr/testify/require"
+)
+
+func TestToRecordings(t *testing.T) {
+	ts := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	f := &File{Log: Log{Entries: []Entry{
+		{
+			StartedDateTime: ts,
+			Request: Request{
+				Method:   "POST",
+				URL:      "https://api.example.com/api/users",
+				Headers:  []NameValue{{Name: ":authority", Value: "api.example.com"}, {Name: "Content-Type", Value: "application/json"}},
+				PostData: &PostData{MimeType: "application/json", Text: `{"name":"john"}`},
+			},
+			Response: Response{Status: 201, Content: Content{MimeType: "application/json", Text: `{"id":1}`}},
+		},
+		{
+			StartedDateTime: ts.Add(time.Second),
+			Request:         Request{Method: "GET", URL: "https://api.example.com/api/users/1?expand=true"},
+			Response:        Response{Status: 200, Content: Content{MimeType: "application/json", Text: "eyJpZCI6MX0=", Encoding: "base64"}},
+		},
+		{
+			Request:  Request{Method: "GET", URL: "https://api.example.com/static/app.js"},
+			Response: Response{Status: 200, Content: Content{MimeType: "application/javascript", Text: "console.log(1)"}},
+		},
+	}}}
+
+	recordings, err := ToRecordings(f, false, nil, nil)
+	require.NoError(t, err)
+	require.Len(t, recordings, 1)
+
+	rcrd := recordings["/api/users"]
+	require.Len(t, rcrd.Body, 2)
+	require.Equal(t, "application/json", rcrd.Headers["Content-Type"])
+	require.NotContains(t, rcrd.Headers, ":authority")
+
+	require.Equal(t, "POST", rcrd.Body[0].Method)
+	require.Equal(t, `{"name":"john"}`, rcrd.Body[0].Body)
+	require.Equal(t, 201, rcrd.Body[0].StatusCode)
+	require.Equal(t, ts, rcrd.Body[0].Timestamp)
+
+	require.Equal(t, "/api/users/:id", rcrd.Body[1].Path)
+	require.Equal(t, "/api/users/1", rcrd.Body[1].RawPath)
+	require.Equal(t, "expand=true", rcrd.Body[1].RawQuery)
+	require.Equal(t, []string{"true"}, rcrd.Body[1].Query["expand"])
+	require.Equal(t, `{"id":1}`, rcrd.Body[1].ResponseBody)
+
+	recordings, err = ToRecordings(f, true, nil, nil)
+	require.NoError(t, err)
+	require.Len(t, recordings, 2)
+}
+
+func TestToRecordings_Redact(t *testing.T) {
+	f := &File{Log: Log{Entries: []Entry{{
+		Request: Request{
+			Method:   "POST",
+			URL:      "https://api.example.com/api/login?token=abc",
+			Headers:  []NameValue{{Name: "Authorization", Value: "Bearer abc"}, {Name: "Cookie", Value: "sid=1"}},
+			PostData: &PostData{MimeType: "application/json", Text: `{"user":"john","password":"hunter2"}`},
+		},
+		Response: Response{
+			Status:  200,
+			Headers: []NameValue{{Name: "Set-Cookie", Value: "sid=2"}},
+			Content: Content{MimeType: "application/json", Text: `{"ok":true}`},
+		},
+	}}}}
+	redactor, err := proxy.NewRedactor(proxy.RedactRules{Fields: []string{"password", "token"}})
+	require.NoError(t, err)
+
+	recordings, err := ToRecordings(f, false, nil, redactor)
+	require.NoError(t, err)
+	rcrd := recordings["/api/login"]
+	require.Equal(t, proxy.Placeholder("Authorization"), rcrd.Headers["Authorization"])
+	row := rcrd.Body[0]
+	require.Equal(t, proxy.Placeholder("Authorization"), row.Header.Get("Authorization"))
+	require.Equal(t, proxy.Placeholder("Cookie"), row.Header.Get("Cookie"))
+	require.Equal(t, proxy.Placeholder("Set-Cookie"), row.ResponseHeader.Get("Set-Cookie"))
+	require.NotContains(t, row.Body, "hunter2")
+	require.NotContains(t, row.RawQuery, "abc")
+}
+
+func TestRoundTrip(t *testing.T) {
+	recordings := map[string]proxy.Recording{
+		"/api/users": {
+			Headers: map[string]string{"Accept": "application/json"},
+			Body: []proxy.BodyRecords{
+				{Method: "POST", Path: "/api/users", RawPath: "/api/users", Body: `{"name":"john"}`, StatusCode: 201, ResponseBody: `{"id":1}`, Header: http.Header{"Accept": {"application/json"}}, ResponseHeader: http.Header{"Location": {"/api/users/1"}}, Timestamp: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), Seq: 1},
+				{Method: "GET", Path: "/api/users/:id", RawPath: "/api/users/1", StatusCode: 200, ResponseBody: `{"id":1}`, Header: http.Header{"Accept": {"application/json"}, "X-Trace": {"a", "b"}}, PathParams: []proxy.PathParam{{Name: "id", Value: "1"}}, Timestamp: time.Date(2026, 1, 1, 0, 0, 1, 0, time.UTC), Seq: 2},
+			},
+		},
+	}
+
+	f, err := FromRecordings(recordings, "http://localhost:8080")
+	require.NoError(t, err)
+	require.Equal(t, "1.2", f.Log.Version)
+	require.Len(t, f.Log.Entries, 2)
+	require.Equal(t, "http://localhost:8080/api/users", f.Log.Entries[0].Request.URL)
+	require.Equal(t, "application/json", f.Log.Entries[0].Request.PostData.MimeType)
+	require.Equal(t, "Created", f.Log.Entries[0].Response.StatusText)
+	require.Nil(t, f.Log.Entries[1].Request.PostData)
+
+	filename := filepath.Join(t.TempDir(), "out.har")
+	require.NoError(t, WriteFile(filename, f))
+	read, err := ReadFile(filename)
+	require.NoError(t, err)
+
+	back, err := ToRecordings(read, false, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, recordings, back)
+}
@@ -0,0 +1,160 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"sync"
+	"time"
+)
+
+// WithCA enables HTTPS interception of CONNECT tunnels. Without a CA tunnels
+// are passed through untouched and their traffic is not recorded.
+func WithCA(ca *CA) Option {
+	return func(r *Recorder) {
+		r.ca = ca
+	}
+}
+
+// forwardTransport never consults HTTP_PROXY, clients pointed at the recorder
+// through that variable would otherwise make it proxy to itself.
+func forwardTransport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = nil
+	return t
+}
+
+func (r *Recorder) forwardProxy() *httputil.ReverseProxy {
+	return &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			u := *pr.In.URL
+			pr.Out.URL = &u
+			pr.Out.Host = ""
+		},
+		Transport: r.transport,
+	}
+}
+
+func (r *Recorder) serveConnect(w http.ResponseWriter, req *http.Request) {
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "testgen: connection can't be hijacked", http.StatusInternalServerError)
+		return
+	}
+	if r.ca == nil {
+		r.tunnel(w, hj, req)
+		return
+	}
+
+	host, _, err := net.SplitHostPort(req.Host)
+	if err != nil {
+		host = req.Host
+	}
+	conn, _, err := hj.Hijack()
+	if err != nil {
+		slog.Error("error hijacking connection", "host", req.Host, "err", err)
+		return
+	}
+	if _, err := io.WriteString(conn, "HTTP/1.1 200 Connection Established\r\n\r\n"); err != nil {
+		conn.Close()
+		return
+	}
+
+	tlsConn := tls.Server(conn, &tls.Config{
+		NextProtos: []string{"http/1.1"},
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			name := hello.ServerName
+			if name == "" {
+				name = host
+			}
+			return r.ca.CertFor(name)
+		},
+	})
+
+	// The decrypted stream is served like any other connection, the handler
+	// only has to put the scheme and host back on each request.
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, inner *http.Request) {
+			inner.URL.Scheme = "https"
+			inner.URL.Host = req.Host
+			r.serve(w, inner)
+		}),
+		ReadHeaderTimeout: 30 * time.Second,
+	}
+	srv.Serve(newConnListener(tlsConn))
+}
+
+// tunnel blindly copies bytes between the client and the requested host.
+func (r *Recorder) tunnel(w http.ResponseWriter, hj http.Hijacker, req *http.Request) {
+	upstream, err := net.DialTimeout("tcp", req.Host, 30*time.Second)
+	if err != nil {
+		slog.Error("error dialing upstream", "host", req.Host, "err", err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	conn, _, err := hj.Hijack()
+	if err != nil {
+		upstream.Close()
+		slog.Error("error hijacking connection", "host", req.Host, "err", err)
+		return
+	}
+	if _, err := io.WriteString(conn, "HTTP/1.1 200 Connection Established\r\n\r\n"); err != nil {
+		conn.Close()
+		upstream.Close()
+		return
+	}
+	slog.Warn("tunnelling without interception, run testgen ca init to record HTTPS", "host", req.Host)
+
+	go func() {
+		io.Copy(upstream, conn)
+		upstream.Close()
+	}()
+	io.Copy(conn, upstream)
+	conn.Close()
+}
+
+// connListener hands a single connection to http.Server.Serve and then
+// reports itself closed once that connection is done.
+type connListener struct {
+	conn net.Conn
+	once sync.Once
+	done chan struct{}
+}
+
+func newConnListener(conn net.Conn) *connListener {
+	return &connListener{conn: conn, done: make(chan struct{})}
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	var conn net.Conn
+	l.once.Do(func() {
+		conn = &closeNotifyConn{Conn: l.conn, done: l.done}
+	})
+	if conn != nil {
+		return conn, nil
+	}
+	<-l.done
+	return nil, net.ErrClosed
+}
+
+func (l *connListener) Close() error {
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+type closeNotifyConn struct {
+	net.Conn
+	once sync.Once
+	done chan struct{}
+}
+
+func (c *closeNotifyConn) Close() error {
+	c.once.Do(func() { close(c.done) })
+	return c.Conn.Close()
+}
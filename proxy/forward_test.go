@@ -0,0 +1,106 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInitCA(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "ca")
+	certPath, err := InitCA(dir)
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(dir, "ca.pem"), certPath)
+
+	_, err = InitCA(dir)
+	require.Error(t, err)
+
+	ca, err := LoadCA(dir)
+	require.NoError(t, err)
+	require.True(t, ca.Certificate().IsCA)
+
+	leaf, err := ca.CertFor("api.example.com")
+	require.NoError(t, err)
+	cached, err := ca.CertFor("api.example.com")
+	require.NoError(t, err)
+	require.Same(t, leaf, cached)
+
+	cert, err := x509.ParseCertificate(leaf.Certificate[0])
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Certificate())
+	_, err = cert.Verify(x509.VerifyOptions{DNSName: "api.example.com", Roots: pool})
+	require.NoError(t, err)
+}
+
+func TestRecorder_Forward(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"path":"` + r.URL.Path + `"}`))
+	})
+	plain := httptest.NewServer(handler)
+	defer plain.Close()
+	secure := httptest.NewTLSServer(handler)
+	defer secure.Close()
+
+	dir := t.TempDir()
+	_, err := InitCA(dir)
+	require.NoError(t, err)
+	ca, err := LoadCA(dir)
+	require.NoError(t, err)
+
+	recorder, err := NewRecorder("", t.TempDir(), WithCA(ca))
+	require.NoError(t, err)
+	recorder.transport = secure.Client().Transport
+	proxySrv := httptest.NewServer(recorder)
+	defer proxySrv.Close()
+
+	proxyURL, err := url.Parse(proxySrv.URL)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Certificate())
+	client := &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyURL(proxyURL),
+		TLSClientConfig: &tls.Config{RootCAs: pool},
+	}}
+
+	resp, err := client.Get(plain.URL + "/api/users/1?expand=true")
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.Equal(t, `{"path":"/api/users/1"}`, string(body))
+
+	resp, err = client.Post(secure.URL+"/api/orders", "application/json", strings.NewReader(`{"qty":1}`))
+	require.NoError(t, err)
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.Equal(t, `{"path":"/api/orders"}`, string(body))
+
+	users := recorder.recordings[strings.TrimPrefix(plain.URL, "http://")+"/api/users"]
+	require.Len(t, users.Body, 1)
+	require.Equal(t, strings.TrimPrefix(plain.URL, "http://"), users.Body[0].Host)
+	require.Equal(t, "/api/users/:id", users.Body[0].Path)
+	require.Equal(t, "expand=true", users.Body[0].RawQuery)
+
+	orders := recorder.recordings[strings.TrimPrefix(secure.URL, "https://")+"/api/orders"]
+	require.Len(t, orders.Body, 1)
+	require.Equal(t, strings.TrimPrefix(secure.URL, "https://"), orders.Body[0].Host)
+	require.Equal(t, `{"qty":1}`, orders.Body[0].Body)
+	require.Equal(t, `{"path":"/api/orders"}`, orders.Body[0].ResponseBody)
+}
+
+func TestRecorder_NoTarget(t *testing.T) {
+	recorder, err := NewRecorder("", t.TempDir())
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	recorder.ServeHTTP(w, httptest.NewRequest("GET", "/api/users", nil))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+}
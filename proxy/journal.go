@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Journal is an append-only JSONL log of completed exchanges. It is written
+// as traffic flows through the recorder so a crashed session can still be
+// compacted into recording files afterwards.
+type Journal struct {
+	path string
+	mu   sync.Mutex
+	f    *os.File
+}
+
+// OpenJournal opens the journal at path for appending. It is locked until
+// Close, see JournalInUse.
+func OpenJournal(path string) (*Journal, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("opening journal %s: %w", path, err)
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("locking journal %s: %w", path, err)
+	}
+	return &Journal{path: path, f: f}, nil
+}
+
+// CreateJournal creates a new journal in outputDir. Its name holds the start
+// time and a random suffix, so recorders started in the same second never
+// share a journal.
+func CreateJournal(outputDir string) (*Journal, error) {
+	pattern := fmt.Sprintf("journal-%s-*.jsonl", time.Now().Format("2006-01-02-150405"))
+	f, err := os.CreateTemp(outputDir, pattern)
+	if err != nil {
+		return nil, fmt.Errorf("creating journal in %s: %w", outputDir, err)
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("locking journal %s: %w", f.Name(), err)
+	}
+	return &Journal{path: f.Name(), f: f}, nil
+}
+
+// JournalInUse reports whether a running recorder still writes to the
+// journal at path.
+func JournalInUse(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, fmt.Errorf("opening journal %s: %w", path, err)
+	}
+	defer f.Close()
+	return fileLocked(f)
+}
+
+func (j *Journal) Path() string {
+	return j.path
+}
+
+// Append writes row as a single line and syncs it to disk.
+func (j *Journal) Append(row BodyRecords) error {
+	data, err := json.Marshal(row)
+	if err != nil {
+		return fmt.Errorf("marshalling journal entry: %w", err)
+	}
+	data = append(data, '\n')
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, err := j.f.Write(data); err != nil {
+		return fmt.Errorf("writing journal %s: %w", j.path, err)
+	}
+	return j.f.Sync()
+}
+
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.f.Close()
+}
+
+// ReadJournal returns the exchanges stored in a journal. A torn last line,
+// left behind when the recorder died mid-write, is skipped.
+func ReadJournal(path string) ([]BodyRecords, error) {
+	rows, err := readLines[BodyRecords](path)
+	if err != nil {
+		return nil, fmt.Errorf("reading journal %s: %w", path, err)
+	}
+	return rows, nil
+}
+
+// Compact folds a journal into per-endpoint recording files and the session
+// timeline in outputDir, grouping exchanges with templater (nil for the
+// built-in templating).
+func Compact(journalPath, outputDir string, templater *Templater) (map[string]Recording, error) {
+	rows, err := ReadJournal(journalPath)
+	if err != nil {
+		return nil, err
+	}
+	recordings := make(map[string]Recording)
+	timeline := make([]TimelineEntry, 0, len(rows))
+	for _, row := range rows {
+		key, row := templater.add(recordings, row, row.Header)
+		timeline = append(timeline, TimelineEntry{Endpoint: key, BodyRecords: row})
+	}
+	if err := WriteRecordings(outputDir, recordings); err != nil {
+		return nil, err
+	}
+	if err := WriteTimeline(timelinePath(outputDir, journalPath), timeline); err != nil {
+		return nil, err
+	}
+	return recordings, nil
+}
@@ -0,0 +1,140 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"reflect"
+)
+
+var mockSkipHeaders = map[string]bool{
+	"Content-Length":    true,
+	"Transfer-Encoding": true,
+	"Connection":        true,
+	"Date":              true,
+}
+
+type Mock struct {
+	matchBody  bool
+	templater  *Templater
+	recordings map[string]Recording
+}
+
+// NewMock serves recordings. The templater must be the one the recordings
+// were grouped with, nil selects the built-in path templating.
+func NewMock(recordings map[string]Recording, matchBody bool, templater *Templater) *Mock {
+	return &Mock{
+		matchBody:  matchBody,
+		templater:  templater,
+		recordings: recordings,
+	}
+}
+
+func (m *Mock) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	reqBody, err := io.ReadAll(req.Body)
+	if err != nil {
+		slog.Error("error reading body", "path", req.URL.Path, "err", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	row, ok := m.match(req.Method, req.URL.Path, req.URL.RawQuery, reqBody)
+	if !ok {
+		slog.Warn("no recording matched", "method", req.Method, "path", req.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		msg, _ := json.Marshal(map[string]string{
+			"error": fmt.Sprintf("testgen mock: no recording matches %s %s", req.Method, req.URL.Path),
+		})
+		w.Write(msg)
+		return
+	}
+
+	for k, v := range row.ResponseHeader {
+		if mockSkipHeaders[http.CanonicalHeaderKey(k)] {
+			continue
+		}
+		w.Header()[k] = v
+	}
+	if w.Header().Get("Content-Type") == "" && json.Valid([]byte(row.ResponseBody)) {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.WriteHeader(row.StatusCode)
+	w.Write([]byte(row.ResponseBody))
+	slog.Info("request mocked", "method", req.Method, "path", req.URL.Path, "status", row.StatusCode)
+}
+
+// match picks the recorded exchange that best fits the request. Rows recorded
+// for the exact same path and query win over rows for the same path, then
+// over rows that only share the templated path, and finally over any other
+// row grouped under the same endpoint.
+func (m *Mock) match(method, path, rawQuery string, body []byte) (BodyRecords, bool) {
+	key, template, _ := m.templater.Template(path)
+	recording, ok := m.recordings[key]
+	if !ok {
+		return BodyRecords{}, false
+	}
+
+	var best BodyRecords
+	bestScore := -1
+	for _, row := range recording.Body {
+		if row.Method != method {
+			continue
+		}
+		if m.matchBody && !bodyEqual(row.Body, body) {
+			continue
+		}
+		score := 0
+		switch {
+		case row.RawPath == path && sameQuery(row.RawQuery, rawQuery):
+			score = 3
+		case row.RawPath == path:
+			score = 2
+		case row.Path == template:
+			score = 1
+		}
+		if score > bestScore {
+			best = row
+			bestScore = score
+		}
+	}
+	return best, bestScore >= 0
+}
+
+func sameQuery(recorded, rawQuery string) bool {
+	a, errA := url.ParseQuery(recorded)
+	b, errB := url.ParseQuery(rawQuery)
+	if errA != nil || errB != nil {
+		return recorded == rawQuery
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func bodyEqual(recorded string, body []byte) bool {
+	var a, b any
+	if json.Unmarshal([]byte(recorded), &a) == nil && json.Unmarshal(body, &b) == nil {
+		return reflect.DeepEqual(a, b)
+	}
+	return bytes.Equal(bytes.TrimSpace([]byte(recorded)), bytes.TrimSpace(body))
+}
+
+// Merge appends the exchanges in src to dst, grouping them under the same
+// endpoint keys.
+func Merge(dst, src map[string]Recording) {
+	for key, item := range src {
+		existing, ok := dst[key]
+		if !ok {
+			dst[key] = item
+			continue
+		}
+		existing.Body = append(existing.Body, item.Body...)
+		if existing.Headers == nil {
+			existing.Headers = item.Headers
+		}
+		dst[key] = existing
+	}
+}
@@ -3,6 +3,7 @@ package proxy
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -11,9 +12,10 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
-	"strconv"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -23,72 +25,131 @@ type Recording struct {
 }
 
 type BodyRecords struct {
-	Path         string    `json:"path"`
-	Body         string    `json:"body"`
-	StatusCode   int       `json:"statusCode"`
-	ResponseBody string    `json:"responseBody"`
-	Timestamp    time.Time `json:"timestamp"`
-	Method       string    `json:"method"`
+	Path           string      `json:"path"`
+	RawPath        string      `json:"rawPath,omitempty"`
+	RawQuery       string      `json:"rawQuery,omitempty"`
+	Query          url.Values  `json:"query,omitempty"`
+	Header         http.Header `json:"header,omitempty"`
+	ResponseHeader http.Header `json:"responseHeader,omitempty"`
+	Body           string      `json:"body"`
+	StatusCode     int         `json:"statusCode"`
+	ResponseBody   string      `json:"responseBody"`
+	Timestamp      time.Time   `json:"timestamp"`
+	Method         string      `json:"method"`
+	Host           string      `json:"host,omitempty"`
+	PathParams     []PathParam `json:"pathParams,omitempty"`
+	// Seq orders exchanges across all endpoints of a recording session, in
+	// the order the requests reached the recorder.
+	Seq int64 `json:"seq,omitempty"`
+	// Client identifies the connection the request came in on.
+	Client string `json:"client,omitempty"`
 }
 
 type Recorder struct {
 	targetURL  *url.URL
 	outputDir  string
+	redactor   *Redactor
+	ca         *CA
+	transport  http.RoundTripper
+	journal    *Journal
+	templater  *Templater
+	seq        atomic.Int64
 	mu         sync.RWMutex
 	recordings map[string]Recording
+	timeline   []TimelineEntry
 }
 
-func NewRecorder(targetURL, outputDir string) (*Recorder, error) {
-	target, err := url.Parse(targetURL)
-	if err != nil {
-		return nil, err
+type Option func(*Recorder)
+
+// WithTemplater groups recordings using t instead of the built-in path
+// templating.
+func WithTemplater(t *Templater) Option {
+	return func(r *Recorder) {
+		r.templater = t
+	}
+}
+
+// WithRedactor scrubs every exchange with rd before it is stored.
+func WithRedactor(rd *Redactor) Option {
+	return func(r *Recorder) {
+		r.redactor = rd
+	}
+}
+
+// NewRecorder creates a recording proxy. Requests with a relative URI are
+// sent to targetURL, absolute-URI requests and CONNECT tunnels are forwarded
+// to the host they name. targetURL may be empty when the recorder is only
+// used as a forward proxy.
+func NewRecorder(targetURL, outputDir string, opts ...Option) (*Recorder, error) {
+	var target *url.URL
+	if targetURL != "" {
+		u, err := url.Parse(targetURL)
+		if err != nil {
+			return nil, err
+		}
+		target = u
 	}
 
 	if err := os.MkdirAll(outputDir, 0o755); err != nil {
 		return nil, err
 	}
 
-	return &Recorder{
+	r := &Recorder{
 		targetURL:  target,
 		outputDir:  outputDir,
+		transport:  forwardTransport(),
 		recordings: make(map[string]Recording),
-	}, nil
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	journal, err := CreateJournal(outputDir)
+	if err != nil {
+		return nil, err
+	}
+	r.journal = journal
+	return r, nil
 }
 
 func (r *Recorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method == http.MethodConnect {
+		r.serveConnect(w, req)
+		return
+	}
+	if !req.URL.IsAbs() && r.targetURL == nil {
+		http.Error(w, "testgen: relative request URI but no target configured", http.StatusBadRequest)
+		return
+	}
+	r.serve(w, req)
+}
+
+func (r *Recorder) serve(w http.ResponseWriter, req *http.Request) {
 	reqBody, err := io.ReadAll(req.Body)
 	if err != nil {
 		slog.Error("error reading body", "path", req.URL.Path, "err", err)
 		return
 	}
 	req.Body = io.NopCloser(bytes.NewBuffer(reqBody))
-	url := req.URL.Path
-	url = normalizeURL(url)
-
-	r.mu.Lock()
-	recording, ok := r.recordings[url]
-	if !ok {
-		recording = Recording{
-			Headers: make(map[string]string),
-			Body:    make([]BodyRecords, 0),
-		}
-
-		for k, v := range req.Header {
-			if len(v) > 0 {
-				recording.Headers[k] = v[0]
-			}
-		}
-		r.recordings[url] = recording
-	}
-	r.mu.Unlock()
 
 	body := BodyRecords{
 		Path:      cleanURL(req.URL.Path),
+		RawPath:   req.URL.Path,
+		RawQuery:  req.URL.RawQuery,
+		Query:     req.URL.Query(),
+		Header:    req.Header.Clone(),
 		Method:    req.Method,
 		Body:      string(reqBody),
 		Timestamp: time.Now(),
+		Seq:       r.seq.Add(1),
+		Client:    req.RemoteAddr,
+	}
+	var proxy *httputil.ReverseProxy
+	if req.URL.IsAbs() {
+		body.Host = req.URL.Host
+		proxy = r.forwardProxy()
+	} else {
+		proxy = httputil.NewSingleHostReverseProxy(r.targetURL)
 	}
-	proxy := httputil.NewSingleHostReverseProxy(r.targetURL)
 	proxy.ErrorHandler = func(w http.ResponseWriter, req *http.Request, err error) {
 		slog.Error("proxy error", "path", req.URL.Path, "err", err)
 		w.WriteHeader(http.StatusBadGateway)
@@ -102,44 +163,222 @@ func (r *Recorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		resp.Body = io.NopCloser(bytes.NewBuffer(respBody))
 		body.StatusCode = resp.StatusCode
 		body.ResponseBody = string(respBody)
-		recording.Body = append(recording.Body, body)
+		body.ResponseHeader = resp.Header.Clone()
+		r.redactor.Redact(&body)
+		if err := r.journal.Append(body); err != nil {
+			slog.Error("error writing journal", "err", err)
+		}
 		r.mu.Lock()
-		r.recordings[url] = recording
+		key, row := r.templater.add(r.recordings, body, body.Header)
+		r.timeline = append(r.timeline, TimelineEntry{Endpoint: key, BodyRecords: row})
 		r.mu.Unlock()
 		return nil
 	}
-	slog.Info("request proxied", "path", req.URL.Path)
+	slog.Info("request proxied", "host", body.Host, "path", req.URL.Path)
 	proxy.ServeHTTP(w, req)
 }
 
+// Save writes the grouped recordings and the session timeline, then removes
+// the session journal once everything in it is safely on disk.
 func (r *Recorder) Save() {
-	fileData := make(map[string]map[string]Recording)
 	r.mu.RLock()
 	defer r.mu.RUnlock()
-	for key, item := range r.recordings {
+	if err := WriteRecordings(r.outputDir, r.recordings); err != nil {
+		slog.Error("error saving recordings, keeping journal", "journal", r.journal.Path(), "err", err)
+		return
+	}
+	if err := WriteTimeline(timelinePath(r.outputDir, r.journal.Path()), r.timeline); err != nil {
+		slog.Error("error saving timeline, keeping journal", "journal", r.journal.Path(), "err", err)
+		return
+	}
+	if err := r.journal.Close(); err != nil {
+		slog.Error("error closing journal", "journal", r.journal.Path(), "err", err)
+		return
+	}
+	if err := os.Remove(r.journal.Path()); err != nil {
+		slog.Error("error removing journal", "journal", r.journal.Path(), "err", err)
+	}
+}
+
+// Append groups an exchange under its endpoint using the built-in path
+// templating. Recording.Headers only keeps the first value of each header
+// from the first exchange seen on an endpoint, the full headers live on every
+// row.
+func Append(recordings map[string]Recording, row BodyRecords, header http.Header) {
+	defaultTemplater.Append(recordings, row, header)
+}
+
+// Append groups an exchange under the endpoint key t derives from its path.
+// Exchanges recorded with a Host, in forward proxy mode, are grouped per host
+// so the same path on two upstreams stays apart.
+func (t *Templater) Append(recordings map[string]Recording, row BodyRecords, header http.Header) {
+	t.add(recordings, row, header)
+}
+
+// add is Append returning the endpoint key and the row as it was stored.
+func (t *Templater) add(recordings map[string]Recording, row BodyRecords, header http.Header) (string, BodyRecords) {
+	if row.RawPath == "" {
+		row.RawPath = row.Path
+	}
+	if row.Header == nil && len(header) > 0 {
+		row.Header = header.Clone()
+	}
+	key, path, params := t.Template(row.RawPath)
+	row.Path = path
+	if len(params) > 0 {
+		row.PathParams = params
+	}
+	if row.RawQuery != "" && row.Query == nil {
+		if query, err := url.ParseQuery(row.RawQuery); err == nil {
+			row.Query = query
+		}
+	}
+
+	recording, ok := recordings[recordingKey(row.Host, key)]
+	if !ok {
+		recording = Recording{
+			Headers: make(map[string]string),
+			Body:    make([]BodyRecords, 0),
+		}
+		for k, v := range header {
+			if len(v) > 0 {
+				recording.Headers[k] = v[0]
+			}
+		}
+	}
+	recording.Body = append(recording.Body, row)
+	recordings[recordingKey(row.Host, key)] = recording
+	return key, row
+}
+
+// recordingKey prefixes endpoint with the host it was recorded from, if any.
+func recordingKey(host, endpoint string) string {
+	return host + endpoint
+}
+
+// splitRecordingKey returns the host and the endpoint of a recording key.
+func splitRecordingKey(key string) (string, string) {
+	if strings.HasPrefix(key, "/") {
+		return "", key
+	}
+	host, endpoint, _ := strings.Cut(key, "/")
+	return host, "/" + endpoint
+}
+
+// WriteRecordings writes one JSON file per endpoint into outputDir, using the
+// same naming scheme as the recording proxy. Files are dated after the first
+// exchange they hold and endpoints recorded from another host get the host in
+// their file name. Exchanges are added to an existing file of the same name,
+// skipping the ones it already holds, so sessions of the same day don't
+// replace each other.
+func WriteRecordings(outputDir string, recordings map[string]Recording) error {
+	fileData := make(map[string]map[string]Recording)
+	for key, item := range recordings {
+		host, endpoint := splitRecordingKey(key)
+		name := cleanPath(endpoint)
+		if host != "" {
+			name = strings.ReplaceAll(host, ":", "_") + "-" + name
+		}
 		filename := fmt.Sprintf("%s-%s.json",
-			time.Now().Format("2006-01-02"),
-			cleanPath(key),
+			recordingDate(item).Format("2006-01-02"),
+			name,
 		)
 		if _, exists := fileData[filename]; !exists {
 			fileData[filename] = make(map[string]Recording)
 		}
 
-		fileData[filename][key] = item
+		fileData[filename][endpoint] = item
 
 	}
+	var errs []error
 	for filename, data := range fileData {
-		mdata, err := json.MarshalIndent(data, "", "  ")
-		if err != nil {
-			slog.Error("error marshalling recording", "err", err)
-			continue
+		if err := writeRecordingFile(filepath.Join(outputDir, filename), data); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// recordingDate returns the time of the first exchange of item, or now when
+// none is timestamped.
+func recordingDate(item Recording) time.Time {
+	var first time.Time
+	for _, row := range item.Body {
+		if !row.Timestamp.IsZero() && (first.IsZero() || row.Timestamp.Before(first)) {
+			first = row.Timestamp
 		}
-		err = os.WriteFile(filepath.Join(r.outputDir, filename), mdata, 0o644)
+	}
+	if first.IsZero() {
+		return time.Now()
+	}
+	return first
+}
+
+// writeRecordingFile merges data into the recording file at path and replaces
+// it once the result is synced to disk.
+func writeRecordingFile(path string, data map[string]Recording) error {
+	merged := make(map[string]Recording)
+	if _, err := os.Stat(path); err == nil {
+		existing, err := ReadRecordings(path)
 		if err != nil {
-			slog.Error("error writing file", "file", filename, "err", err)
-			continue
+			return err
 		}
+		merged = existing
 	}
+	for key, item := range data {
+		if existing, ok := merged[key]; ok {
+			item.Body = slices.DeleteFunc(slices.Clone(item.Body), func(row BodyRecords) bool {
+				return slices.ContainsFunc(existing.Body, row.sameExchange)
+			})
+		}
+		Merge(merged, map[string]Recording{key: item})
+	}
+
+	mdata, err := json.MarshalIndent(merged, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshalling recording %s: %w", path, err)
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("writing file %s: %w", path, err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(mdata)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0o644)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		return fmt.Errorf("writing file %s: %w", path, err)
+	}
+	return nil
+}
+
+// sameExchange reports whether o is the same recorded exchange as r, such as
+// a journal compacted twice.
+func (r BodyRecords) sameExchange(o BodyRecords) bool {
+	return r.Seq == o.Seq && r.Timestamp.Equal(o.Timestamp) && r.Method == o.Method &&
+		r.RawPath == o.RawPath && r.RawQuery == o.RawQuery && r.Client == o.Client
+}
+
+func ReadRecordings(filename string) (map[string]Recording, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("reading recordings %s: %w", filename, err)
+	}
+	recordings := make(map[string]Recording)
+	if err := json.Unmarshal(data, &recordings); err != nil {
+		return nil, fmt.Errorf("parsing recordings %s: %w", filename, err)
+	}
+	return recordings, nil
 }
 
 func cleanPath(url string) string {
@@ -166,28 +405,11 @@ func cleanPath(url string) string {
 }
 
 func cleanURL(url string) string {
-	b := strings.SplitSeq(url, "/")
-	for o := range b {
-		if _, err := strconv.Atoi(o); err == nil {
-			url = strings.ReplaceAll(url, o, ":id")
-		}
-	}
-	return url
+	_, path, _ := defaultTemplater.Template(url)
+	return path
 }
 
 func normalizeURL(url string) string {
-	parts := strings.Split(url, "/")
-	normalized := make([]string, 0, len(parts))
-
-	for _, part := range parts {
-		if part == "" {
-			continue
-		}
-		if _, err := strconv.Atoi(part); err == nil {
-			continue
-		}
-		normalized = append(normalized, part)
-	}
-
-	return "/" + strings.Join(normalized, "/")
+	key, _, _ := defaultTemplater.Template(url)
+	return key
 }
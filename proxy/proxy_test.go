@@ -1,7 +1,15 @@
 package proxy
 
 import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -39,7 +47,7 @@ func TestCleanURL(t *testing.T) {
 		{"/api/users", "/api/users"},
 		{"/api/users/123", "/api/users/:id"},
 		{"/api/users/123/profile", "/api/users/:id/profile"},
-		{"/api/456/items/789", "/api/:id/items/:id"},
+		{"/api/456/items/789", "/api/:apiID/items/:itemID"},
 		{"/api/abc/def", "/api/abc/def"},
 	}
 
@@ -69,3 +77,352 @@ func TestNormalizeURL(t *testing.T) {
 		require.Equal(t, tt.expected, result)
 	}
 }
+
+func TestMock(t *testing.T) {
+	recordings := map[string]Recording{
+		"/api/users": {
+			Body: []BodyRecords{
+				{Method: "GET", Path: "/api/users", RawPath: "/api/users", StatusCode: 200, ResponseBody: `[{"id":1},{"id":2}]`},
+				{Method: "GET", Path: "/api/users/:id", RawPath: "/api/users/1", StatusCode: 200, ResponseBody: `{"id":1}`},
+				{Method: "GET", Path: "/api/users/:id", RawPath: "/api/users/2", StatusCode: 200, ResponseBody: `{"id":2}`},
+				{Method: "POST", Path: "/api/users", RawPath: "/api/users", Body: `{"name":"john"}`, StatusCode: 201, ResponseBody: `{"id":3}`},
+				{Method: "POST", Path: "/api/users", RawPath: "/api/users", Body: `{"name":""}`, StatusCode: 422, ResponseBody: "invalid"},
+			},
+		},
+	}
+
+	tests := []struct {
+		name         string
+		matchBody    bool
+		method       string
+		path         string
+		body         string
+		expectedCode int
+		expectedBody string
+	}{
+		{"collection", false, "GET", "/api/users", "", 200, `[{"id":1},{"id":2}]`},
+		{"exact item", false, "GET", "/api/users/2", "", 200, `{"id":2}`},
+		{"templated item", false, "GET", "/api/users/99", "", 200, `{"id":1}`},
+		{"first post", false, "POST", "/api/users", `{"name":""}`, 201, `{"id":3}`},
+		{"body match", true, "POST", "/api/users", `{ "name": "" }`, 422, "invalid"},
+		{"body mismatch", true, "POST", "/api/users", `{"name":"jane"}`, 404, ""},
+		{"unknown method", false, "DELETE", "/api/users/1", "", 404, ""},
+		{"unknown path", false, "GET", "/api/orders", "", 404, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := NewMock(recordings, tt.matchBody, nil)
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			mock.ServeHTTP(w, req)
+			require.Equal(t, tt.expectedCode, w.Code)
+			if tt.expectedBody != "" {
+				require.Equal(t, tt.expectedBody, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestMerge(t *testing.T) {
+	dst := map[string]Recording{
+		"/api/users": {Body: []BodyRecords{{Method: "GET"}}},
+	}
+	Merge(dst, map[string]Recording{
+		"/api/users":  {Body: []BodyRecords{{Method: "POST"}}, Headers: map[string]string{"Accept": "*/*"}},
+		"/api/orders": {Body: []BodyRecords{{Method: "GET"}}},
+	})
+	require.Len(t, dst, 2)
+	require.Len(t, dst["/api/users"].Body, 2)
+	require.Equal(t, "*/*", dst["/api/users"].Headers["Accept"])
+	require.Len(t, dst["/api/orders"].Body, 1)
+}
+
+func TestAppend(t *testing.T) {
+	recordings := make(map[string]Recording)
+	Append(recordings, BodyRecords{Method: "GET", RawPath: "/api/users/42"}, http.Header{"Accept": {"application/json", "text/plain"}})
+	Append(recordings, BodyRecords{Method: "GET", Path: "/api/users", RawQuery: "page=2&tag=a&tag=b"}, http.Header{"Accept": {"*/*"}})
+
+	require.Len(t, recordings, 1)
+	rcrd := recordings["/api/users"]
+	require.Equal(t, map[string]string{"Accept": "application/json"}, rcrd.Headers)
+	require.Len(t, rcrd.Body, 2)
+	require.Equal(t, "/api/users/:id", rcrd.Body[0].Path)
+	require.Equal(t, "/api/users", rcrd.Body[1].RawPath)
+	require.Equal(t, url.Values{"page": {"2"}, "tag": {"a", "b"}}, rcrd.Body[1].Query)
+}
+
+func TestAppend_Hosts(t *testing.T) {
+	recordings := make(map[string]Recording)
+	Append(recordings, BodyRecords{Method: "GET", RawPath: "/v1/users", Host: "api.example.com"}, nil)
+	Append(recordings, BodyRecords{Method: "GET", RawPath: "/v1/users", Host: "auth.example.com:8443"}, nil)
+	Append(recordings, BodyRecords{Method: "GET", RawPath: "/v1/users"}, nil)
+	require.Len(t, recordings, 3)
+
+	dir := t.TempDir()
+	require.NoError(t, WriteRecordings(dir, recordings))
+	for _, name := range []string{"api.example.com-users", "auth.example.com_8443-users", "users"} {
+		saved, err := ReadRecordings(filepath.Join(dir, time.Now().Format("2006-01-02")+"-"+name+".json"))
+		require.NoError(t, err)
+		require.Len(t, saved["/v1/users"].Body, 1, name)
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", "/api/users/7")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":7}`))
+	}))
+	defer backend.Close()
+
+	recorder, err := NewRecorder(backend.URL, t.TempDir())
+	require.NoError(t, err)
+
+	req := httptest.NewRequest("POST", "/api/users?notify=true", strings.NewReader(`{"name":"john"}`))
+	req.Header.Add("X-Tenant", "a")
+	req.Header.Add("X-Tenant", "b")
+	w := httptest.NewRecorder()
+	recorder.ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	rcrd := recorder.recordings["/api/users"]
+	require.Len(t, rcrd.Body, 1)
+	row := rcrd.Body[0]
+	require.Equal(t, `{"name":"john"}`, row.Body)
+	require.Equal(t, "notify=true", row.RawQuery)
+	require.Equal(t, []string{"a", "b"}, row.Header["X-Tenant"])
+	require.Equal(t, "/api/users/7", row.ResponseHeader.Get("Location"))
+	require.Equal(t, `{"id":7}`, row.ResponseBody)
+}
+
+func TestRedactor(t *testing.T) {
+	rd, err := NewRedactor(RedactRules{
+		Headers: []string{"x-api-key"},
+		Fields:  []string{"password", "$.card.number"},
+	})
+	require.NoError(t, err)
+
+	row := BodyRecords{
+		Header:         http.Header{"Authorization": {"Bearer abc"}, "X-Api-Key": {"k1"}, "Accept": {"*/*"}},
+		ResponseHeader: http.Header{"Set-Cookie": {"a=1", "b=2"}},
+		Body:           `{"user":{"name":"john","password":"hunter2"},"card":{"number":4111111111111111,"cvc":"123"},"items":[{"password":"x"}]}`,
+		ResponseBody:   `{"id":1,"name":"john"}`,
+		Query:          url.Values{"password": {"p"}, "page": {"2"}},
+		RawQuery:       "password=p&page=2",
+	}
+	rd.Redact(&row)
+
+	require.Equal(t, []string{"${TESTGEN_AUTHORIZATION}"}, row.Header["Authorization"])
+	require.Equal(t, []string{"${TESTGEN_X_API_KEY}"}, row.Header["X-Api-Key"])
+	require.Equal(t, []string{"*/*"}, row.Header["Accept"])
+	require.Equal(t, []string{"${TESTGEN_SET_COOKIE}", "${TESTGEN_SET_COOKIE}"}, row.ResponseHeader["Set-Cookie"])
+	require.JSONEq(t, `{"user":{"name":"john","password":"${TESTGEN_PASSWORD}"},"card":{"number":"${TESTGEN_CARD_NUMBER}","cvc":"123"},"items":[{"password":"${TESTGEN_PASSWORD}"}]}`, row.Body)
+	require.Equal(t, `{"id":1,"name":"john"}`, row.ResponseBody)
+	require.Equal(t, "page=2&password=%24%7BTESTGEN_PASSWORD%7D", row.RawQuery)
+
+	_, err = NewRedactor(RedactRules{Fields: []string{"$.card..number"}})
+	require.Error(t, err)
+	_, err = NewRedactor(RedactRules{Fields: []string{"$"}})
+	require.Error(t, err)
+}
+
+func TestPlaceholder(t *testing.T) {
+	require.Equal(t, "${TESTGEN_CARD_NUMBER}", Placeholder("card.number"))
+	env, ok := ParsePlaceholder("${TESTGEN_CARD_NUMBER}")
+	require.True(t, ok)
+	require.Equal(t, "TESTGEN_CARD_NUMBER", env)
+	_, ok = ParsePlaceholder("Bearer ${TESTGEN_TOKEN}")
+	require.False(t, ok)
+
+	t.Setenv("TESTGEN_TOKEN", "abc")
+	require.Equal(t, "Bearer abc", ExpandPlaceholders("Bearer ${TESTGEN_TOKEN}"))
+}
+
+func TestJournal(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "journal.jsonl")
+	journal, err := OpenJournal(path)
+	require.NoError(t, err)
+	require.NoError(t, journal.Append(BodyRecords{Method: "POST", RawPath: "/api/users", Body: `{"name":"john"}`, StatusCode: 201, Header: http.Header{"Accept": {"*/*"}}}))
+	require.NoError(t, journal.Append(BodyRecords{Method: "GET", RawPath: "/api/users/1", StatusCode: 200}))
+	require.NoError(t, journal.Close())
+
+	// simulate a crash in the middle of a write
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+	require.NoError(t, err)
+	f.WriteString(`{"method":"DELETE","rawPa`)
+	f.Close()
+
+	rows, err := ReadJournal(path)
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+
+	recordings, err := Compact(path, dir, nil)
+	require.NoError(t, err)
+	require.Len(t, recordings["/api/users"].Body, 2)
+	require.Equal(t, "*/*", recordings["/api/users"].Headers["Accept"])
+
+	files, err := filepath.Glob(filepath.Join(dir, "*-users.json"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	saved, err := ReadRecordings(files[0])
+	require.NoError(t, err)
+	require.Equal(t, "/api/users/:id", saved["/api/users"].Body[1].Path)
+
+	timeline, err := ReadTimeline(filepath.Join(dir, "timeline-journal.jsonl"))
+	require.NoError(t, err)
+	require.Len(t, timeline, 2)
+	require.Equal(t, "/api/users", timeline[1].Endpoint)
+}
+
+func TestCompact_SameEndpoint(t *testing.T) {
+	dir := t.TempDir()
+	day := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	write := func(name string, row BodyRecords) string {
+		journal, err := OpenJournal(filepath.Join(dir, name))
+		require.NoError(t, err)
+		require.NoError(t, journal.Append(row))
+		require.NoError(t, journal.Close())
+		return journal.Path()
+	}
+	first := write("journal-a.jsonl", BodyRecords{Method: "GET", RawPath: "/api/users/1", StatusCode: 200, Timestamp: day, Seq: 1})
+	second := write("journal-b.jsonl", BodyRecords{Method: "POST", RawPath: "/api/users", StatusCode: 201, Timestamp: day.Add(time.Hour), Seq: 1})
+
+	for _, journal := range []string{first, second, first} {
+		_, err := Compact(journal, dir, nil)
+		require.NoError(t, err)
+	}
+
+	// dated after the session, holding both journals once
+	saved, err := ReadRecordings(filepath.Join(dir, "2026-01-01-users.json"))
+	require.NoError(t, err)
+	require.Len(t, saved["/api/users"].Body, 2)
+	require.Equal(t, "GET", saved["/api/users"].Body[0].Method)
+	require.Equal(t, "POST", saved["/api/users"].Body[1].Method)
+}
+
+func TestCreateJournal(t *testing.T) {
+	dir := t.TempDir()
+	first, err := CreateJournal(dir)
+	require.NoError(t, err)
+	second, err := CreateJournal(dir)
+	require.NoError(t, err)
+	require.NotEqual(t, first.Path(), second.Path())
+	require.Regexp(t, `^journal-\d{4}-\d{2}-\d{2}-\d{6}-\d+\.jsonl$`, filepath.Base(first.Path()))
+
+	inUse, err := JournalInUse(first.Path())
+	require.NoError(t, err)
+	if runtime.GOOS != "windows" {
+		require.True(t, inUse)
+	}
+
+	require.NoError(t, first.Close())
+	inUse, err = JournalInUse(first.Path())
+	require.NoError(t, err)
+	require.False(t, inUse)
+	require.NoError(t, second.Close())
+}
+
+func TestRecorder_SaveRemovesJournal(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer backend.Close()
+
+	dir := t.TempDir()
+	recorder, err := NewRecorder(backend.URL, dir)
+	require.NoError(t, err)
+	recorder.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/users", nil))
+
+	rows, err := ReadJournal(recorder.journal.Path())
+	require.NoError(t, err)
+	require.Len(t, rows, 1)
+
+	recorder.Save()
+	_, err = os.Stat(recorder.journal.Path())
+	require.True(t, os.IsNotExist(err))
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+}
+
+func TestRecorder_Timeline(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer backend.Close()
+
+	dir := t.TempDir()
+	recorder, err := NewRecorder(backend.URL, dir)
+	require.NoError(t, err)
+	for _, path := range []string{"/api/users", "/api/orders", "/api/users/7"} {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = "10.0.0.1:5000"
+		recorder.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	recorder.Save()
+
+	files, err := filepath.Glob(filepath.Join(dir, "timeline-*.jsonl"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	timeline, err := ReadTimeline(files[0])
+	require.NoError(t, err)
+	require.Len(t, timeline, 3)
+
+	endpoints := make([]string, 0)
+	for i, entry := range timeline {
+		require.Equal(t, int64(i+1), entry.Seq)
+		require.Equal(t, "10.0.0.1:5000", entry.Client)
+		endpoints = append(endpoints, entry.Endpoint)
+	}
+	require.Equal(t, []string{"/api/users", "/api/orders", "/api/users"}, endpoints)
+	require.Equal(t, "/api/users/:id", timeline[2].Path)
+	require.Equal(t, int64(3), recorder.recordings["/api/users"].Body[1].Seq)
+}
+
+func TestTemplater(t *testing.T) {
+	templater, err := NewTemplater(PathRules{
+		Routes:     []string{"/users/{id}/posts/{postID}", "/teams/:slug"},
+		IDPatterns: []string{`INV-\d+`},
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		input        string
+		expectedKey  string
+		expectedPath string
+		params       []PathParam
+	}{
+		{"/api/users", "/api/users", "/api/users", []PathParam{}},
+		{"/api/users/123", "/api/users", "/api/users/:id", []PathParam{{"id", "123"}}},
+		{"/users/3f2a1b4c-9d8e-4f7a-8b6c-5d4e3f2a1b0c", "/users", "/users/:id", []PathParam{{"id", "3f2a1b4c-9d8e-4f7a-8b6c-5d4e3f2a1b0c"}}},
+		{"/events/01ARZ3NDEKTSV4RRFFQ69G5FAV", "/events", "/events/:id", []PathParam{{"id", "01ARZ3NDEKTSV4RRFFQ69G5FAV"}}},
+		{"/orders/507f1f77bcf86cd799439011/items", "/orders/items", "/orders/:id/items", []PathParam{{"id", "507f1f77bcf86cd799439011"}}},
+		{"/orders/ord_abc123", "/orders", "/orders/:id", []PathParam{{"id", "ord_abc123"}}},
+		{"/invoices/INV-2041", "/invoices", "/invoices/:id", []PathParam{{"id", "INV-2041"}}},
+		{"/api/user_profile", "/api/user_profile", "/api/user_profile", []PathParam{}},
+		{"/api/deadbeefcafebabe", "/api/deadbeefcafebabe", "/api/deadbeefcafebabe", []PathParam{}},
+		{"/users/alice/posts/hello-world", "/users/posts", "/users/:id/posts/:postID", []PathParam{{"id", "alice"}, {"postID", "hello-world"}}},
+		{"/teams/core", "/teams", "/teams/:slug", []PathParam{{"slug", "core"}}},
+		{"/api/users/7/posts/9", "/api/users/posts", "/api/users/:userID/posts/:postID", []PathParam{{"userID", "7"}, {"postID", "9"}}},
+		{"/categories/3/order-items/4/addresses/5", "/categories/order-items/addresses", "/categories/:categoryID/order-items/:orderItemID/addresses/:addressID", []PathParam{{"categoryID", "3"}, {"orderItemID", "4"}, {"addressID", "5"}}},
+		{"/orders/1/2", "/orders", "/orders/:orderID/:id2", []PathParam{{"orderID", "1"}, {"id2", "2"}}},
+		{"/users/1/friends/users/2", "/users/friends/users", "/users/:userID/friends/users/:id2", []PathParam{{"userID", "1"}, {"id2", "2"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			key, path, params := templater.Template(tt.input)
+			require.Equal(t, tt.expectedKey, key)
+			require.Equal(t, tt.expectedPath, path)
+			require.Equal(t, tt.params, params)
+		})
+	}
+
+	_, err = NewTemplater(PathRules{Routes: []string{"/users/{id"}})
+	require.Error(t, err)
+	_, err = NewTemplater(PathRules{IDPatterns: []string{"("}})
+	require.Error(t, err)
+}
@@ -0,0 +1,204 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var defaultRedactHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+var placeholderRe = regexp.MustCompile(`^\$\{(TESTGEN_[A-Z0-9_]+)\}$`)
+
+var placeholderExpandRe = regexp.MustCompile(`\$\{TESTGEN_[A-Z0-9_]+\}`)
+
+var placeholderNameRe = regexp.MustCompile(`[^A-Z0-9]+`)
+
+type RedactRules struct {
+	// Headers are redacted on both requests and responses. Authorization,
+	// Proxy-Authorization, Cookie and Set-Cookie are always included.
+	Headers []string `json:"headers"`
+	// Fields match JSON body fields either by key name at any depth
+	// ("password") or by JSONPath from the document root ("$.card.number").
+	Fields []string `json:"fields"`
+}
+
+type Redactor struct {
+	headers map[string]bool
+	keys    map[string]bool
+	paths   [][]string
+}
+
+func NewRedactor(rules RedactRules) (*Redactor, error) {
+	r := &Redactor{
+		headers: make(map[string]bool),
+		keys:    make(map[string]bool),
+	}
+	for _, h := range append(defaultRedactHeaders, rules.Headers...) {
+		r.headers[http.CanonicalHeaderKey(strings.TrimSpace(h))] = true
+	}
+	for _, f := range rules.Fields {
+		f = strings.TrimSpace(f)
+		if !strings.HasPrefix(f, "$") {
+			if f == "" {
+				return nil, fmt.Errorf("empty redaction field")
+			}
+			r.keys[f] = true
+			continue
+		}
+		parts := strings.Split(strings.TrimPrefix(f, "$"), ".")
+		if len(parts) < 2 || parts[0] != "" || slices.Contains(parts[1:], "") {
+			return nil, fmt.Errorf("invalid redaction path %q, expected $.key or $.key.nested", f)
+		}
+		r.paths = append(r.paths, parts[1:])
+	}
+	return r, nil
+}
+
+// Placeholder returns the stable value that replaces a redacted secret. The
+// generator turns it into an environment variable lookup.
+func Placeholder(name string) string {
+	return "${" + PlaceholderEnv(name) + "}"
+}
+
+// PlaceholderEnv returns the environment variable backing the placeholder for name.
+func PlaceholderEnv(name string) string {
+	env := placeholderNameRe.ReplaceAllString(strings.ToUpper(name), "_")
+	return "TESTGEN_" + strings.Trim(env, "_")
+}
+
+// ParsePlaceholder reports the environment variable referenced by s if s is
+// a redaction placeholder.
+func ParsePlaceholder(s string) (string, bool) {
+	m := placeholderRe.FindStringSubmatch(s)
+	if m == nil {
+		return "", false
+	}
+	return m[1], true
+}
+
+// ExpandPlaceholders replaces a redaction placeholder with the value of its
+// environment variable, leaving anything else untouched.
+func ExpandPlaceholders(s string) string {
+	return placeholderExpandRe.ReplaceAllStringFunc(s, func(p string) string {
+		return os.Getenv(p[2 : len(p)-1])
+	})
+}
+
+// Redact scrubs secrets from a recorded exchange in place.
+func (r *Redactor) Redact(row *BodyRecords) {
+	if r == nil {
+		return
+	}
+	r.redactHeader(row.Header)
+	r.redactHeader(row.ResponseHeader)
+	row.Body = r.redactBody(row.Body)
+	row.ResponseBody = r.redactBody(row.ResponseBody)
+	if len(row.Query) > 0 {
+		changed := false
+		for k, v := range row.Query {
+			if !r.keys[k] {
+				continue
+			}
+			for i := range v {
+				v[i] = Placeholder(k)
+			}
+			changed = true
+		}
+		if changed {
+			row.RawQuery = row.Query.Encode()
+		}
+	}
+}
+
+func (r *Redactor) redactHeader(header http.Header) {
+	for k, v := range header {
+		if !r.headers[http.CanonicalHeaderKey(k)] {
+			continue
+		}
+		for i := range v {
+			v[i] = Placeholder(k)
+		}
+	}
+}
+
+func (r *Redactor) redactBody(body string) string {
+	if len(r.keys) == 0 && len(r.paths) == 0 {
+		return body
+	}
+	dec := json.NewDecoder(strings.NewReader(body))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return body
+	}
+	changed := r.redactKeys(doc)
+	for _, path := range r.paths {
+		if redactPath(doc, path, strings.Join(path, ".")) {
+			changed = true
+		}
+	}
+	if !changed {
+		return body
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(doc); err != nil {
+		return body
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+func (r *Redactor) redactKeys(v any) bool {
+	changed := false
+	switch t := v.(type) {
+	case map[string]any:
+		for k, item := range t {
+			if r.keys[k] {
+				t[k] = Placeholder(k)
+				changed = true
+				continue
+			}
+			if r.redactKeys(item) {
+				changed = true
+			}
+		}
+	case []any:
+		for _, item := range t {
+			if r.redactKeys(item) {
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+func redactPath(v any, path []string, name string) bool {
+	switch t := v.(type) {
+	case map[string]any:
+		item, ok := t[path[0]]
+		if !ok {
+			return false
+		}
+		if len(path) == 1 {
+			t[path[0]] = Placeholder(name)
+			return true
+		}
+		return redactPath(item, path[1:], name)
+	case []any:
+		changed := false
+		for _, item := range t {
+			if redactPath(item, path, name) {
+				changed = true
+			}
+		}
+		return changed
+	}
+	return false
+}
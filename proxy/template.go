@@ -0,0 +1,251 @@
+package proxy
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+var (
+	uuidRe     = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	ulidRe     = regexp.MustCompile(`^[0-9A-HJKMNP-TV-Za-hjkmnp-tv-z]{26}$`)
+	hexRe      = regexp.MustCompile(`^[0-9a-fA-F]{16,}$`)
+	prefixedRe = regexp.MustCompile(`^[A-Za-z]{1,10}_[A-Za-z0-9]{6,}$`)
+	digitRe    = regexp.MustCompile(`[0-9]`)
+	numericRe  = regexp.MustCompile(`^[0-9]+$`)
+)
+
+// builtinMatchers recognise the ID formats seen in most APIs. The ULID, hex
+// and prefixed formats also require a digit so plain words never match.
+var builtinMatchers = []func(string) bool{
+	numericRe.MatchString,
+	uuidRe.MatchString,
+	func(s string) bool { return ulidRe.MatchString(s) && digitRe.MatchString(s) },
+	func(s string) bool { return hexRe.MatchString(s) && digitRe.MatchString(s) },
+	func(s string) bool { return prefixedRe.MatchString(s) && digitRe.MatchString(s) },
+}
+
+type PathRules struct {
+	// Routes are route patterns like /users/{id}/posts/{postID} or
+	// /users/:id. They win over the built-in ID detection.
+	Routes []string `json:"routes"`
+	// IDPatterns are extra regular expressions matching a whole path
+	// segment that should be treated as an ID.
+	IDPatterns []string `json:"idPatterns"`
+}
+
+type PathParam struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type routePattern struct {
+	segments []string
+	params   map[int]string
+}
+
+// Templater turns concrete request paths into the endpoint key recordings are
+// grouped by and a templated path with named parameters.
+type Templater struct {
+	routes   []routePattern
+	matchers []func(string) bool
+}
+
+var defaultTemplater = &Templater{matchers: builtinMatchers}
+
+func NewTemplater(rules PathRules) (*Templater, error) {
+	t := &Templater{matchers: slices.Clone(builtinMatchers)}
+	for _, p := range rules.IDPatterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid id pattern %q: %w", p, err)
+		}
+		t.matchers = append(t.matchers, func(s string) bool {
+			loc := re.FindStringIndex(s)
+			return loc != nil && loc[0] == 0 && loc[1] == len(s)
+		})
+	}
+	for _, r := range rules.Routes {
+		route, err := parseRoute(r)
+		if err != nil {
+			return nil, err
+		}
+		t.routes = append(t.routes, route)
+	}
+	return t, nil
+}
+
+func parseRoute(pattern string) (routePattern, error) {
+	route := routePattern{params: make(map[int]string)}
+	for i, seg := range splitPath(pattern) {
+		name := ""
+		switch {
+		case strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}"):
+			name = seg[1 : len(seg)-1]
+		case strings.HasPrefix(seg, ":"):
+			name = seg[1:]
+		}
+		if name == "" && (strings.ContainsAny(seg, "{}") || seg == ":") {
+			return routePattern{}, fmt.Errorf("invalid route pattern %q", pattern)
+		}
+		if name != "" {
+			route.params[i] = name
+		}
+		route.segments = append(route.segments, seg)
+	}
+	if len(route.segments) == 0 {
+		return routePattern{}, fmt.Errorf("empty route pattern %q", pattern)
+	}
+	return route, nil
+}
+
+func splitPath(path string) []string {
+	segments := make([]string, 0)
+	for _, seg := range strings.Split(path, "/") {
+		if seg != "" {
+			segments = append(segments, seg)
+		}
+	}
+	return segments
+}
+
+func (t *Templater) templater() *Templater {
+	if t == nil {
+		return defaultTemplater
+	}
+	return t
+}
+
+func (t *Templater) isID(seg string) bool {
+	for _, m := range t.matchers {
+		if m(seg) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Templater) matchRoute(path string) (routePattern, []string, bool) {
+	segments := splitPath(path)
+	for _, route := range t.routes {
+		if len(route.segments) != len(segments) {
+			continue
+		}
+		ok := true
+		for i, seg := range route.segments {
+			if _, isParam := route.params[i]; !isParam && seg != segments[i] {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return route, segments, true
+		}
+	}
+	return routePattern{}, nil, false
+}
+
+// Template returns the endpoint key for path (its segments without IDs), the
+// templated path with IDs replaced by :name placeholders, and the values of
+// those parameters in order.
+func (t *Templater) Template(path string) (string, string, []PathParam) {
+	t = t.templater()
+	if route, segments, ok := t.matchRoute(path); ok {
+		keyParts := make([]string, 0, len(segments))
+		tmplParts := make([]string, 0, len(segments))
+		params := make([]PathParam, 0, len(route.params))
+		for i, seg := range segments {
+			if name, isParam := route.params[i]; isParam {
+				tmplParts = append(tmplParts, ":"+name)
+				params = append(params, PathParam{Name: name, Value: seg})
+				continue
+			}
+			keyParts = append(keyParts, seg)
+			tmplParts = append(tmplParts, seg)
+		}
+		return "/" + strings.Join(keyParts, "/"), "/" + strings.Join(tmplParts, "/"), params
+	}
+
+	parts := strings.Split(path, "/")
+	keyParts := make([]string, 0, len(parts))
+	params := make([]PathParam, 0)
+	idx := make([]int, 0)
+	for i, part := range parts {
+		if part == "" {
+			continue
+		}
+		if t.isID(part) {
+			params = append(params, PathParam{Name: "id", Value: part})
+			idx = append(idx, i)
+			continue
+		}
+		keyParts = append(keyParts, part)
+	}
+	if len(params) > 1 {
+		nameParams(parts, idx, params)
+	}
+	for n, i := range idx {
+		parts[i] = ":" + params[n].Name
+	}
+	return "/" + strings.Join(keyParts, "/"), strings.Join(parts, "/"), params
+}
+
+// nameParams names the detected IDs of a path with several of them after the
+// segment before each, so /users/1/posts/2 becomes /users/:userID/posts/:postID.
+// IDs without a distinct name are numbered instead.
+func nameParams(parts []string, idx []int, params []PathParam) {
+	used := make(map[string]bool)
+	for n, i := range idx {
+		name := ""
+		if i > 0 && parts[i-1] != "" && !slices.Contains(idx, i-1) {
+			name = paramName(parts[i-1])
+		}
+		if name == "" || used[name] {
+			name = fmt.Sprintf("id%d", n+1)
+		}
+		used[name] = true
+		params[n].Name = name
+	}
+}
+
+// paramName turns a collection segment such as order-items into the name of
+// the ID that follows it, orderItemID.
+func paramName(segment string) string {
+	words := strings.FieldsFunc(segment, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(words) == 0 || !unicode.IsLetter([]rune(words[0])[0]) {
+		return ""
+	}
+	var sb strings.Builder
+	for n, w := range words {
+		if n == len(words)-1 {
+			w = singular(w)
+		}
+		r := []rune(strings.ToLower(w))
+		if n > 0 {
+			r[0] = unicode.ToUpper(r[0])
+		}
+		sb.WriteString(string(r))
+	}
+	return sb.String() + "ID"
+}
+
+// singular strips the plural ending of an English word.
+func singular(word string) string {
+	lower := strings.ToLower(word)
+	switch {
+	case strings.HasSuffix(lower, "ies") && len(word) > 3:
+		return word[:len(word)-3] + "y"
+	case strings.HasSuffix(lower, "sses"), strings.HasSuffix(lower, "xes"),
+		strings.HasSuffix(lower, "ches"), strings.HasSuffix(lower, "shes"):
+		return word[:len(word)-2]
+	case strings.HasSuffix(lower, "ss"), strings.HasSuffix(lower, "us"):
+		return word
+	case strings.HasSuffix(lower, "s") && len(word) > 1:
+		return word[:len(word)-1]
+	}
+	return word
+}
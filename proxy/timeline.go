@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// TimelineEntry is one exchange in the order it reached the recorder, along
+// with the endpoint key it is grouped under in the recording files.
+type TimelineEntry struct {
+	Endpoint string `json:"endpoint"`
+	BodyRecords
+}
+
+// timelinePath names the timeline after the journal of the same session, so
+// journal-2006-01-02-150405-123.jsonl becomes timeline-2006-01-02-150405-123.jsonl.
+func timelinePath(outputDir, journalPath string) string {
+	name := strings.TrimPrefix(filepath.Base(journalPath), "journal-")
+	return filepath.Join(outputDir, "timeline-"+name)
+}
+
+func sortTimeline(timeline []TimelineEntry) {
+	slices.SortStableFunc(timeline, func(a, b TimelineEntry) int {
+		return cmp.Compare(a.Seq, b.Seq)
+	})
+}
+
+// WriteTimeline writes the entries as JSONL ordered by sequence number.
+func WriteTimeline(path string, timeline []TimelineEntry) error {
+	timeline = slices.Clone(timeline)
+	sortTimeline(timeline)
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, entry := range timeline {
+		if err := enc.Encode(entry); err != nil {
+			return fmt.Errorf("marshalling timeline entry %d: %w", entry.Seq, err)
+		}
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("writing timeline %s: %w", path, err)
+	}
+	return nil
+}
+
+func ReadTimeline(path string) ([]TimelineEntry, error) {
+	timeline, err := readLines[TimelineEntry](path)
+	if err != nil {
+		return nil, fmt.Errorf("reading timeline %s: %w", path, err)
+	}
+	sortTimeline(timeline)
+	return timeline, nil
+}
+
+// readLines decodes a JSONL file, skipping lines that can't be parsed such as
+// a torn last line.
+func readLines[T any](path string) ([]T, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]T, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var item T
+		if err := json.Unmarshal(scanner.Bytes(), &item); err != nil {
+			slog.Warn("skipping unreadable line", "file", path, "line", line, "err", err)
+			continue
+		}
+		items = append(items, item)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
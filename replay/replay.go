@@ -0,0 +1,214 @@
+package replay
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/muzzii255/testgen/proxy"
+)
+
+var skipHeaders = map[string]bool{
+	"Content-Length":  true,
+	"Host":            true,
+	"Accept-Encoding": true,
+	"Connection":      true,
+}
+
+type Result struct {
+	Endpoint   string
+	Method     string
+	Path       string
+	Index      int
+	WantStatus int
+	GotStatus  int
+	Diffs      []string
+	Err        error
+}
+
+func (r Result) Failed() bool {
+	return r.Err != nil || r.WantStatus != r.GotStatus || len(r.Diffs) > 0
+}
+
+type Replayer struct {
+	Target *url.URL
+	Client *http.Client
+}
+
+func NewReplayer(target string, client *http.Client) (*Replayer, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("parsing target %s: %w", target, err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("target %s must be an absolute URL", target)
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Replayer{Target: u, Client: client}, nil
+}
+
+func (r *Replayer) Replay(recordings map[string]proxy.Recording) []Result {
+	endpoints := make([]string, 0, len(recordings))
+	for key := range recordings {
+		endpoints = append(endpoints, key)
+	}
+	sort.Strings(endpoints)
+
+	results := make([]Result, 0)
+	for _, ep := range endpoints {
+		rcrd := recordings[ep]
+		for i, row := range rcrd.Body {
+			res := r.replayOne(rcrd, row)
+			res.Endpoint = ep
+			res.Index = i
+			results = append(results, res)
+		}
+	}
+	return results
+}
+
+func (r *Replayer) replayOne(rcrd proxy.Recording, row proxy.BodyRecords) Result {
+	path := row.RawPath
+	if path == "" {
+		path = row.Path
+	}
+	target := r.Target.JoinPath(path)
+	target.RawQuery = row.RawQuery
+	if len(row.Query) > 0 {
+		query := make(url.Values, len(row.Query))
+		for k, v := range row.Query {
+			for _, item := range v {
+				query.Add(k, proxy.ExpandPlaceholders(item))
+			}
+		}
+		target.RawQuery = query.Encode()
+	}
+	if row.RawQuery != "" {
+		path += "?" + row.RawQuery
+	}
+	res := Result{Method: row.Method, Path: path, WantStatus: row.StatusCode}
+
+	var body io.Reader
+	if row.Body != "" {
+		body = bytes.NewBufferString(proxy.ExpandPlaceholders(row.Body))
+	}
+	req, err := http.NewRequest(row.Method, target.String(), body)
+	if err != nil {
+		res.Err = fmt.Errorf("building request: %w", err)
+		return res
+	}
+	if row.Header != nil {
+		for k, v := range row.Header {
+			if skipHeaders[http.CanonicalHeaderKey(k)] {
+				continue
+			}
+			for _, item := range v {
+				req.Header.Add(k, proxy.ExpandPlaceholders(item))
+			}
+		}
+	} else {
+		for k, v := range rcrd.Headers {
+			if skipHeaders[http.CanonicalHeaderKey(k)] {
+				continue
+			}
+			req.Header.Set(k, proxy.ExpandPlaceholders(v))
+		}
+	}
+
+	resp, err := r.Client.Do(req)
+	if err != nil {
+		res.Err = fmt.Errorf("sending request: %w", err)
+		return res
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		res.Err = fmt.Errorf("reading response body: %w", err)
+		return res
+	}
+	res.GotStatus = resp.StatusCode
+	res.Diffs = Diff(row.ResponseBody, string(respBody))
+	return res
+}
+
+// Diff compares two response bodies. JSON bodies are compared structurally
+// and each difference is reported with its JSONPath, anything else is
+// compared byte for byte.
+func Diff(want, got string) []string {
+	var wantJson, gotJson any
+	wantErr := json.Unmarshal([]byte(want), &wantJson)
+	gotErr := json.Unmarshal([]byte(got), &gotJson)
+	if wantErr != nil || gotErr != nil {
+		if strings.TrimSpace(want) == strings.TrimSpace(got) {
+			return nil
+		}
+		return []string{fmt.Sprintf("body: want %q, got %q", want, got)}
+	}
+	diffs := make([]string, 0)
+	diffValue("$", wantJson, gotJson, &diffs)
+	return diffs
+}
+
+func diffValue(path string, want, got any, diffs *[]string) {
+	switch w := want.(type) {
+	case map[string]any:
+		g, ok := got.(map[string]any)
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(w)+len(g))
+		for k := range w {
+			keys = append(keys, k)
+		}
+		for k := range g {
+			if _, ok := w[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			wv, wok := w[k]
+			gv, gok := g[k]
+			switch {
+			case !gok:
+				*diffs = append(*diffs, fmt.Sprintf("%s.%s: missing", path, k))
+			case !wok:
+				*diffs = append(*diffs, fmt.Sprintf("%s.%s: unexpected %s", path, k, encode(gv)))
+			default:
+				diffValue(path+"."+k, wv, gv, diffs)
+			}
+		}
+		return
+	case []any:
+		g, ok := got.([]any)
+		if !ok {
+			break
+		}
+		if len(w) != len(g) {
+			*diffs = append(*diffs, fmt.Sprintf("%s: want %d items, got %d", path, len(w), len(g)))
+		}
+		for i := range min(len(w), len(g)) {
+			diffValue(fmt.Sprintf("%s[%d]", path, i), w[i], g[i], diffs)
+		}
+		return
+	}
+	if !reflect.DeepEqual(want, got) {
+		*diffs = append(*diffs, fmt.Sprintf("%s: want %s, got %s", path, encode(want), encode(got)))
+	}
+}
+
+func encode(v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
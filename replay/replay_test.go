@@ -0,0 +1,101 @@
+package replay
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/muzzii255/testgen/proxy"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		want     string
+		got      string
+		expected []string
+	}{
+		{"equal json", `{"a":1,"b":[1,2]}`, `{"b":[1,2],"a":1}`, []string{}},
+		{"changed value", `{"a":1}`, `{"a":2}`, []string{"$.a: want 1, got 2"}},
+		{"missing key", `{"a":1,"b":2}`, `{"a":1}`, []string{"$.b: missing"}},
+		{"unexpected key", `{"a":1}`, `{"a":1,"c":"x"}`, []string{`$.c: unexpected "x"`}},
+		{"nested", `{"u":{"tags":["a"]}}`, `{"u":{"tags":["b"]}}`, []string{`$.u.tags[0]: want "a", got "b"`}},
+		{"array length", `[1,2]`, `[1]`, []string{"$: want 2 items, got 1"}},
+		{"type change", `{"a":"1"}`, `{"a":1}`, []string{`$.a: want "1", got 1`}},
+		{"plain text equal", "ok\n", "ok", nil},
+		{"plain text differs", "ok", "nope", []string{`body: want "ok", got "nope"`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.expected, Diff(tt.want, tt.got))
+		})
+	}
+}
+
+func TestReplayer_Replay(t *testing.T) {
+	// the handler runs on the server's goroutine, the created request is
+	// checked once Replay returns
+	var (
+		mu          sync.Mutex
+		createToken string
+		createBody  string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/api/users":
+			mu.Lock()
+			createToken, createBody = r.Header.Get("X-Token"), string(body)
+			mu.Unlock()
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"id":1,"name":"john"}`))
+		case r.Method == http.MethodGet && r.URL.Path == "/api/users/1":
+			w.Write([]byte(`{"id":1,"name":"jane"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	recordings := map[string]proxy.Recording{
+		"/api/users": {
+			Headers: map[string]string{"X-Token": "secret", "Content-Length": "15"},
+			Body: []proxy.BodyRecords{
+				{Method: "POST", Path: "/api/users", RawPath: "/api/users", Body: `{"name":"john"}`, StatusCode: 201, ResponseBody: `{"id":1,"name":"john"}`},
+				{Method: "GET", Path: "/api/users/:id", RawPath: "/api/users/1", StatusCode: 200, ResponseBody: `{"id":1,"name":"john"}`},
+				{Method: "DELETE", Path: "/api/users/:id", RawPath: "/api/users/1", StatusCode: 204},
+			},
+		},
+	}
+
+	replayer, err := NewReplayer(srv.URL, nil)
+	require.NoError(t, err)
+	results := replayer.Replay(recordings)
+	require.Len(t, results, 3)
+
+	mu.Lock()
+	require.Equal(t, "secret", createToken)
+	require.JSONEq(t, `{"name":"john"}`, createBody)
+	mu.Unlock()
+
+	require.False(t, results[0].Failed())
+
+	require.True(t, results[1].Failed())
+	require.Equal(t, "/api/users/1", results[1].Path)
+	require.Equal(t, []string{`$.name: want "john", got "jane"`}, results[1].Diffs)
+
+	require.True(t, results[2].Failed())
+	require.Equal(t, 204, results[2].WantStatus)
+	require.Equal(t, 404, results[2].GotStatus)
+}
+
+func TestNewReplayer(t *testing.T) {
+	_, err := NewReplayer("localhost:8080", nil)
+	require.Error(t, err)
+	_, err = NewReplayer("http://localhost:8080", nil)
+	require.NoError(t, err)
+}
@@ -3,9 +3,12 @@ package structgen
 import (
 	"fmt"
 	"go/ast"
+	"log/slog"
+	"path"
 	"reflect"
 	"strings"
 
+	"github.com/muzzii255/testgen/proxy"
 	"golang.org/x/tools/go/packages"
 )
 
@@ -19,11 +22,17 @@ func getJsonTag(s string) string {
 type StructGenerator struct {
 	BaseDir string
 	PkgPath string
+	// Vars maps dotted JSON paths of the mapped body to variables holding
+	// their value at run time, as a string. They are written instead of the
+	// recorded value.
+	Vars map[string]string
+	// pkgName is the name of the loaded package, used to qualify its types.
+	pkgName string
 }
 
 func (sg *StructGenerator) loadStructDefinition(structName string) (*ast.StructType, error) {
 	cfg := &packages.Config{
-		Mode: packages.NeedTypes | packages.NeedSyntax | packages.NeedTypesInfo,
+		Mode: packages.NeedName | packages.NeedFiles | packages.NeedSyntax,
 		Dir:  sg.BaseDir,
 	}
 	pkgs, err := packages.Load(cfg, sg.PkgPath)
@@ -44,6 +53,7 @@ func (sg *StructGenerator) loadStructDefinition(structName string) (*ast.StructT
 					return true
 				}
 				dataStruct = s
+				sg.pkgName = pkg.Name
 				return false
 			})
 		}
@@ -89,7 +99,7 @@ func getFieldType(expr ast.Expr) (string, bool, bool) {
 func sliceString(values []string) string {
 	res := "{"
 	for _, i := range values {
-		res = res + fmt.Sprintf(`"%s",`, i)
+		res = res + fmt.Sprintf(`%q,`, i)
 	}
 	res = res + "}"
 	return res
@@ -99,16 +109,122 @@ func sliceNonString(typ string) bool {
 	types := map[string]bool{
 		"[]float64": true, "[]int64": true, "[]int32": true, "[]int16": true,
 		"[]float32": true, "[]int": true, "[]uint64": true, "[]uint32": true,
-		"[]uint16": true, "[]uint8": true, "[]int8": true,
+		"[]uint16": true, "[]uint8": true, "[]int8": true, "[]uint": true,
+		"[]bool": true,
 	}
 	return types[typ]
 }
 
-func (sg *StructGenerator) mapItem(field string, value any, ptr, builtin bool, r *ast.Field) string {
-	var row string
-	if strings.Contains(field, "time.time") {
-		row = fmt.Sprintf("%s: %s,\n", r.Names[0], "time.Now()")
-		return row
+// compatible reports whether a JSON value can be written as a constant of
+// the builtin type typ.
+func compatible(typ string, value any) bool {
+	switch v := value.(type) {
+	case string:
+		return typ == "string"
+	case bool:
+		return typ == "bool"
+	case float64:
+		if strings.HasPrefix(typ, "float") {
+			return true
+		}
+		return strings.Contains(typ, "int") && v == float64(int64(v))
+	case int:
+		return strings.Contains(typ, "int") || strings.HasPrefix(typ, "float")
+	}
+	return false
+}
+
+// mapVar writes a field holding the variable v instead of its recorded value,
+// or returns an empty string when the field can't hold it.
+func (sg *StructGenerator) mapVar(field string, value any, ptr, builtin bool, r *ast.Field, v string) string {
+	base := strings.TrimPrefix(field, "*")
+	var expr string
+	switch {
+	case strings.HasPrefix(field, "[]") || strings.HasPrefix(field, "map["):
+		return ""
+	case builtin && base == "string":
+		expr = v
+	case builtin && base == "int64":
+		expr = fmt.Sprintf("parseID(t, %s)", v)
+	case builtin && (strings.Contains(base, "int") || strings.HasPrefix(base, "float")):
+		expr = fmt.Sprintf("%s(parseID(t, %s))", base, v)
+	case builtin || strings.Contains(base, ".") || base == "unknown" || base == "any":
+		return ""
+	default:
+		// a named type of the struct's package, converted like the recorded
+		// value would be
+		if _, ok := value.(string); ok {
+			expr = fmt.Sprintf("%s%s(%s)", sg.qualifier(), base, v)
+		} else {
+			expr = fmt.Sprintf("%s%s(parseID(t, %s))", sg.qualifier(), base, v)
+		}
+	}
+	if ptr {
+		expr = "Ptr(" + expr + ")"
+	}
+	return fmt.Sprintf("%s: %s,\n", r.Names[0], expr)
+}
+
+// mapValue writes a field from value, or from the variable vars holds for
+// key.
+func (sg *StructGenerator) mapValue(key, field string, value any, ptr, builtin bool, r *ast.Field, vars map[string]string) string {
+	if v, ok := Var(vars, key); ok {
+		if item := sg.mapVar(field, value, ptr, builtin, r, v); item != "" {
+			return item
+		}
+	}
+	return sg.mapItem(field, value, ptr, builtin, r, SubVars(vars, key))
+}
+
+// Var returns the variable vars holds for the field key of a body. Like the
+// recorded values themselves, keys fall back to a case insensitive match.
+func Var(vars map[string]string, key string) (string, bool) {
+	if v, ok := vars[key]; ok {
+		return v, true
+	}
+	for path, v := range vars {
+		if strings.EqualFold(path, key) {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// SubVars returns the vars of the fields nested under key, matched like Var.
+func SubVars(vars map[string]string, key string) map[string]string {
+	var sub map[string]string
+	for path, v := range vars {
+		parent, rest, ok := strings.Cut(path, ".")
+		if !ok || !strings.EqualFold(parent, key) {
+			continue
+		}
+		if sub == nil {
+			sub = make(map[string]string)
+		}
+		sub[rest] = v
+	}
+	return sub
+}
+
+func (sg *StructGenerator) mapItem(field string, value any, ptr, builtin bool, r *ast.Field, vars map[string]string) string {
+	name := r.Names[0]
+	base := strings.TrimPrefix(field, "*")
+	if value == nil {
+		return ""
+	}
+	if base == "time.Time" {
+		if ptr {
+			return fmt.Sprintf("%s: Ptr(time.Now()),\n", name)
+		}
+		return fmt.Sprintf("%s: time.Now(),\n", name)
+	}
+	// maps, interfaces and types of other packages have no literal here
+	if strings.Contains(base, ".") && !strings.HasPrefix(field, "map[") {
+		slog.Warn("skipping field of a type from another package, set it in the generated test", "field", name.Name, "type", field)
+		return ""
+	}
+	if strings.HasPrefix(field, "map[") || base == "unknown" || base == "any" {
+		return ""
 	}
 	if field == "[]string" {
 		if arr, ok := value.([]any); ok {
@@ -116,88 +232,150 @@ func (sg *StructGenerator) mapItem(field string, value any, ptr, builtin bool, r
 			for i, v := range arr {
 				strs[i] = fmt.Sprint(v)
 			}
-			row = fmt.Sprintf("%s: %s%s,\n", r.Names[0], field, sliceString(strs))
-			return row
+			return fmt.Sprintf("%s: %s%s,\n", name, field, sliceString(strs))
 		}
-	}
-
-	if field == "map[string]any" {
-		return row
+		return ""
 	}
 
 	if strings.HasPrefix(field, "[]") && !builtin {
-		structType := strings.TrimPrefix(field, "[]")
-		pkgPath := cleanPkgPath(sg.PkgPath)
-		if arr, ok := value.([]any); ok {
-			row = fmt.Sprintf("%s: []%s%s{\n", r.Names[0], pkgPath, structType)
-			for _, item := range arr {
-				if itemMap, ok := item.(map[string]any); ok {
-					nestedStruct, err := sg.MapField(structType, itemMap)
-					if err != nil {
-						continue
-					}
-					row += fmt.Sprintf("%s,\n", nestedStruct)
+		// elements are written without their type, which also fits []*T
+		elem := strings.TrimPrefix(field, "[]")
+		arr, ok := value.([]any)
+		if !ok {
+			return ""
+		}
+		elemType := strings.TrimPrefix(elem, "*")
+		row := fmt.Sprintf("%s: []%s%s%s{\n", name, strings.TrimSuffix(elem, elemType), sg.qualifier(), elemType)
+		for _, item := range arr {
+			if itemMap, ok := item.(map[string]any); ok {
+				nestedStruct, err := sg.mapFields(elemType, itemMap, nil)
+				if err != nil {
+					continue
 				}
+				row += fmt.Sprintf("%s,\n", nestedStruct)
 			}
-			row += "},\n"
-			return row
 		}
+		return row + "},\n"
 	}
 
 	if sliceNonString(field) {
+		if _, ok := value.([]any); !ok {
+			return ""
+		}
 		v := fmt.Sprintf("%v", value)
 		v = strings.ReplaceAll(v, " ", ",")
 		v = strings.ReplaceAll(v, "[", "")
 		v = strings.ReplaceAll(v, "]", "")
 
-		row = fmt.Sprintf("%s:%s{%s,},\n", r.Names[0], field, v)
-		return row
+		return fmt.Sprintf("%s:%s{%s,},\n", name, field, v)
 	}
 
 	if builtin {
-		value = getCorrectValue(value)
-		if !ptr {
-			row = fmt.Sprintf("%s: %s,\n", r.Names[0], value)
-
-			return row
+		if str, ok := value.(string); ok {
+			if env, ok := proxy.ParsePlaceholder(str); ok {
+				// redacted values are strings, they can't fill other types
+				if base != "string" {
+					return ""
+				}
+				value = fmt.Sprintf("os.Getenv(%q)", env)
+				if ptr {
+					return fmt.Sprintf("%s: Ptr(%s),\n", name, value)
+				}
+				return fmt.Sprintf("%s: %s,\n", name, value)
+			}
+		}
+		if !compatible(base, value) {
+			return ""
 		}
 		if ptr {
-			row = fmt.Sprintf("%s: %s,\n", r.Names[0], fmt.Sprintf("Ptr(%s)", value))
-
-			return row
+			return fmt.Sprintf("%s: %s(%s),\n", name, ptrFunc(field), getCorrectValue(value))
 		}
+		return fmt.Sprintf("%s: %s,\n", name, getCorrectValue(value))
 	}
 
-	if !builtin {
-		var st string
-		pkgPath := cleanPkgPath(sg.PkgPath)
-		if dt, ok := value.(map[string]any); ok {
-			s, err := sg.MapField(field, dt)
-			if err != nil {
-				return row
-			}
-			st = s
-		}
-		if !ptr {
-			row = fmt.Sprintf("%s: %s%s%s,\n", r.Names[0], pkgPath, field, st)
-			return row
+	// a struct or a named type of the struct's package
+	var lit string
+	switch v := value.(type) {
+	case map[string]any:
+		st, err := sg.mapFields(base, v, vars)
+		if err != nil {
+			return ""
 		}
-		if ptr {
-			row = fmt.Sprintf("%s: Ptr(%s%s%s),\n", r.Names[0], pkgPath, field, st)
+		lit = sg.qualifier() + base + st
+	case string, bool, float64:
+		lit = fmt.Sprintf("%s%s(%s)", sg.qualifier(), base, getCorrectValue(v))
+	default:
+		return ""
+	}
+	if ptr {
+		return fmt.Sprintf("%s: Ptr(%s),\n", name, lit)
+	}
+	return fmt.Sprintf("%s: %s,\n", name, lit)
+}
 
-			return row
-		}
+// ptrFunc returns the Ptr call for a builtin type. Untyped constants only
+// infer string, bool, int and float64, other types need an explicit type
+// argument.
+func ptrFunc(field string) string {
+	switch base := strings.TrimPrefix(field, "*"); base {
+	case "string", "bool", "int", "float64":
+		return "Ptr"
+	default:
+		return "Ptr[" + base + "]"
 	}
-	return row
 }
 
+// cleanPkgPath returns the qualifier of a package folder, its last element.
+// Types of the root package are not qualified.
 func cleanPkgPath(pkgPath string) string {
-	if pkgPath == "./" {
-		pkgPath = ""
+	pkgPath = strings.Trim(strings.TrimPrefix(pkgPath, "./"), "/")
+	if pkgPath == "" || pkgPath == "." {
+		return ""
+	}
+	return path.Base(pkgPath) + "."
+}
+
+// qualifier returns the prefix of the struct package's types outside of it,
+// using the package name once it is loaded.
+func (sg *StructGenerator) qualifier() string {
+	q := cleanPkgPath(sg.PkgPath)
+	if q != "" && sg.pkgName != "" {
+		return sg.pkgName + "."
 	}
-	pkgPath = strings.ReplaceAll(pkgPath, "./", "")
-	pkgPath = pkgPath + "."
-	return pkgPath
+	return q
+}
+
+// jsonKey returns the JSON key of a struct field, from its tag or its name.
+// ok is false for fields encoding/json skips.
+func jsonKey(r *ast.Field) (string, bool) {
+	if len(r.Names) == 0 || !r.Names[0].IsExported() {
+		return "", false
+	}
+	key := r.Names[0].Name
+	if r.Tag != nil {
+		name, _, _ := strings.Cut(getJsonTag(r.Tag.Value), ",")
+		if name == "-" {
+			return "", false
+		}
+		if name != "" {
+			key = name
+		}
+	}
+	return key, true
+}
+
+// lookup returns the value of key in rawJson. Like encoding/json it falls
+// back to a case insensitive match.
+func lookup(rawJson map[string]any, key string) (any, bool) {
+	if v, ok := rawJson[key]; ok {
+		return v, true
+	}
+	for k, v := range rawJson {
+		if strings.EqualFold(k, key) {
+			return v, true
+		}
+	}
+	return nil, false
 }
 
 func getCorrectValue(value any) string {
@@ -205,7 +383,7 @@ func getCorrectValue(value any) string {
 		return fmt.Sprintf("%d", data)
 	}
 	if data, ok := value.(string); ok {
-		return fmt.Sprintf(`"%s"`, data)
+		return fmt.Sprintf("%q", data)
 	}
 	if data, ok := value.(bool); ok {
 		return fmt.Sprintf(`%v`, data)
@@ -217,6 +395,10 @@ func getCorrectValue(value any) string {
 }
 
 func (sg *StructGenerator) MapField(stName string, rawJson map[string]any) (string, error) {
+	return sg.mapFields(stName, rawJson, sg.Vars)
+}
+
+func (sg *StructGenerator) mapFields(stName string, rawJson map[string]any, vars map[string]string) (string, error) {
 	dataStruct, err := sg.loadStructDefinition(stName)
 	if err != nil {
 		return "", err
@@ -228,19 +410,86 @@ func (sg *StructGenerator) MapField(stName string, rawJson map[string]any) (stri
 
 	sb.WriteString("{\n")
 	for _, r := range dataStruct.Fields.List {
-		if r.Tag == nil {
+		key, ok := jsonKey(r)
+		if !ok {
 			continue
 		}
-
-		jsonTag := getJsonTag(r.Tag.Value)
-		value, ok := rawJson[jsonTag]
+		value, ok := lookup(rawJson, key)
 		if !ok {
 			continue
 		}
 		ft, ptr, builtin := getFieldType(r.Type)
-		sb.WriteString(sg.mapItem(ft, value, ptr, builtin, r))
+		sb.WriteString(sg.mapValue(key, ft, value, ptr, builtin, r, vars))
 
 	}
 	sb.WriteString("}")
 	return sb.String(), nil
 }
+
+// qualifiedType renders a field type as it must be written outside the
+// struct's package, qualifying the package's own types.
+func (sg *StructGenerator) qualifiedType(expr ast.Expr) string {
+	switch t := expr.(type) {
+	case *ast.Ident:
+		if isBuiltinType(t.Name) || t.Name == "any" {
+			return t.Name
+		}
+		return sg.qualifier() + t.Name
+	case *ast.StarExpr:
+		return "*" + sg.qualifiedType(t.X)
+	case *ast.ArrayType:
+		return "[]" + sg.qualifiedType(t.Elt)
+	case *ast.MapType:
+		return fmt.Sprintf("map[%s]%s", sg.qualifiedType(t.Key), sg.qualifiedType(t.Value))
+	case *ast.SelectorExpr:
+		return fmt.Sprintf("%s.%s", t.X, t.Sel)
+	case *ast.InterfaceType:
+		return "any"
+	default:
+		return "any"
+	}
+}
+
+// MapPartial maps a partial update body onto an anonymous struct holding only
+// the fields present in rawJson. Fields are pointers, so a recorded null is
+// sent as null and untouched fields are not sent at all. The result is a
+// complete composite literal, type included.
+func (sg *StructGenerator) MapPartial(stName string, rawJson map[string]any) (string, error) {
+	dataStruct, err := sg.loadStructDefinition(stName)
+	if err != nil {
+		return "", err
+	}
+	if dataStruct == nil {
+		return "", fmt.Errorf("struct not found %s %s", stName, sg.PkgPath)
+	}
+	var typ, lit strings.Builder
+	typ.WriteString("struct {\n")
+	lit.WriteString("{\n")
+	for _, r := range dataStruct.Fields.List {
+		jsonTag, ok := jsonKey(r)
+		if !ok {
+			continue
+		}
+		value, ok := lookup(rawJson, jsonTag)
+		if !ok {
+			continue
+		}
+		fieldType := sg.qualifiedType(r.Type)
+		ptr := true
+		switch {
+		case strings.HasPrefix(fieldType, "[]"), strings.HasPrefix(fieldType, "map["), fieldType == "any":
+			ptr = false
+		case !strings.HasPrefix(fieldType, "*"):
+			fieldType = "*" + fieldType
+		}
+		fmt.Fprintf(&typ, "%s %s `json:%q`\n", r.Names[0], fieldType, jsonTag)
+		if value == nil {
+			continue
+		}
+		ft, _, builtin := getFieldType(r.Type)
+		lit.WriteString(sg.mapValue(jsonTag, strings.TrimPrefix(ft, "*"), value, ptr, builtin, r, sg.Vars))
+	}
+	typ.WriteString("}")
+	lit.WriteString("}")
+	return typ.String() + lit.String(), nil
+}
@@ -1,6 +1,8 @@
 package structgen
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -66,9 +68,9 @@ func TestCleanPkgPath(t *testing.T) {
 		input    string
 		expected string
 	}{
-		{"./", "."},
+		{"./", ""},
 		{"./models", "models."},
-		{"./pkg/api", "pkg/api."},
+		{"./pkg/api", "api."},
 		{"models", "models."},
 	}
 
@@ -108,6 +110,136 @@ func TestGetJsonTag(t *testing.T) {
 
 	for _, tt := range tests {
 		result := getJsonTag(tt.input)
-		require.Equal(t,tt.expected,result)
+		require.Equal(t, tt.expected, result)
 	}
 }
+
+func TestPtrFunc(t *testing.T) {
+	require.Equal(t, "Ptr", ptrFunc("string"))
+	require.Equal(t, "Ptr", ptrFunc("*int"))
+	require.Equal(t, "Ptr[int64]", ptrFunc("*int64"))
+	require.Equal(t, "Ptr[float32]", ptrFunc("float32"))
+}
+
+func TestMapPartial(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/app\n\ngo 1.25\n"), 0o644))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "models"), 0o755))
+	src := `package models
+
+type User struct {
+	ID      int64    ` + "`json:\"id\"`" + `
+	Name    string   ` + "`json:\"name,omitempty\"`" + `
+	Age     *int     ` + "`json:\"age\"`" + `
+	Tags    []string ` + "`json:\"tags\"`" + `
+	Address Address  ` + "`json:\"address\"`" + `
+}
+
+type Address struct {
+	City string ` + "`json:\"city\"`" + `
+}
+`
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "models", "models.go"), []byte(src), 0o644))
+
+	sg := StructGenerator{BaseDir: dir, PkgPath: "./models"}
+	got, err := sg.MapPartial("User", map[string]any{
+		"id":      float64(5),
+		"name":    "jane",
+		"age":     nil,
+		"tags":    []any{"a"},
+		"address": map[string]any{"city": "x"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "struct {\n"+
+		"ID *int64 `json:\"id\"`\n"+
+		"Name *string `json:\"name\"`\n"+
+		"Age *int `json:\"age\"`\n"+
+		"Tags []string `json:\"tags\"`\n"+
+		"Address *models.Address `json:\"address\"`\n"+
+		"}{\n"+
+		"ID: Ptr[int64](5),\n"+
+		"Name: Ptr(\"jane\"),\n"+
+		"Tags: []string{\"a\",},\n"+
+		"Address: Ptr(models.Address{\nCity: \"x\",\n}),\n"+
+		"}", got)
+
+	_, err = sg.MapPartial("Missing", map[string]any{})
+	require.Error(t, err)
+}
+
+func TestMapField(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/app\n\ngo 1.25\n"), 0o644))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "internal", "api"), 0o755))
+	src := `package api
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Status string
+
+type User struct {
+	Name      string
+	Email     string    ` + "`json:\"email,omitempty\"`" + `
+	Secret    string    ` + "`json:\"-\"`" + `
+	Age       int       ` + "`json:\"age\"`" + `
+	Status    Status    ` + "`json:\"status\"`" + `
+	CreatedAt time.Time ` + "`json:\"created_at\"`" + `
+	Seen      *time.Time ` + "`json:\"seen\"`" + `
+	Meta      map[string]any ` + "`json:\"meta\"`" + `
+	Addresses []*Address ` + "`json:\"addresses\"`" + `
+	Ref       uuid.UUID ` + "`json:\"ref\"`" + `
+}
+
+type Address struct {
+	City string ` + "`json:\"city\"`" + `
+}
+`
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "internal", "api", "api.go"), []byte(src), 0o644))
+
+	sg := StructGenerator{BaseDir: dir, PkgPath: "./internal/api"}
+	got, err := sg.MapField("User", map[string]any{
+		"name":       `say "hi"`,
+		"email":      "j@x.io",
+		"Secret":     "s",
+		"age":        "old",
+		"status":     "active",
+		"created_at": "2026-01-01T00:00:00Z",
+		"seen":       "2026-01-01T00:00:00Z",
+		"meta":       map[string]any{"a": "b"},
+		"addresses":  []any{map[string]any{"city": "x"}},
+		"ref":        "4f0c9a8e-1b2c-4d3e-8f90-123456789abc",
+	})
+	require.NoError(t, err)
+	require.Equal(t, "{\n"+
+		"Name: \"say \\\"hi\\\"\",\n"+
+		"Email: \"j@x.io\",\n"+
+		"Status: api.Status(\"active\"),\n"+
+		"CreatedAt: time.Now(),\n"+
+		"Seen: Ptr(time.Now()),\n"+
+		"Addresses: []*api.Address{\n{\nCity: \"x\",\n},\n},\n"+
+		"}", got)
+
+	sg.Vars = map[string]string{"age": "usersID", "status": "usersStatus", "name": "usersName"}
+	got, err = sg.MapField("User", map[string]any{"name": "john", "age": 7.0, "status": "active"})
+	require.NoError(t, err)
+	require.Equal(t, "{\nName: usersName,\nAge: int(parseID(t, usersID)),\nStatus: api.Status(usersStatus),\n}", got)
+}
+
+func TestVars(t *testing.T) {
+	vars := map[string]string{"user.ID": "usersID", "orderId": "ordersID"}
+	v, ok := Var(vars, "orderId")
+	require.True(t, ok)
+	require.Equal(t, "ordersID", v)
+	v, ok = Var(vars, "OrderID")
+	require.True(t, ok)
+	require.Equal(t, "ordersID", v)
+	_, ok = Var(vars, "user")
+	require.False(t, ok)
+
+	require.Equal(t, map[string]string{"ID": "usersID"}, SubVars(vars, "User"))
+	require.Nil(t, SubVars(vars, "order"))
+}